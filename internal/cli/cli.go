@@ -0,0 +1,385 @@
+// Package cli is what the jwt-tools subcommands share: the global flags, how the key sources
+// they name are loaded and the exit codes
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ps258/jwt-tools/jwttools"
+	"github.com/ps258/jwt-tools/keys"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNoKeys is returned by KeySource when none of the key flags are given
+var ErrNoKeys = errors.New("no keys given, use --jwks-url, --jwks-file, --key, --cert, --hmac-secret, --hmac-secret-file or --hmac-secret-env")
+
+// The exit codes are the same for every subcommand. Like diff(1) and grep(1) a negative answer is
+// 1 and anything that stops the command from getting an answer is 2
+const (
+	ExitOK       = 0 // the command worked, the token is valid, the files are the same
+	ExitRejected = 1 // the token didn't verify, the files differ
+	ExitError    = 2 // bad flags, unreadable files, keys that can't be fetched
+)
+
+// verify says why a token was rejected, keeping 0 for valid. As 2 is a claim check failing, bad
+// flags are 5 rather than ExitError
+const (
+	ExitSignature = 1 // the signature didn't verify, or no key could verify it
+	ExitClaims    = 2 // the signature verified but a claim check failed
+	ExitKeys      = 3 // the keys couldn't be loaded or fetched
+	ExitMalformed = 4 // the token couldn't be read
+	ExitUsage     = 5 // bad flags, or a trust config, trust bundle or address that can't be used
+)
+
+// Files is a flag that can be given more than once
+type Files []string
+
+func (f *Files) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *Files) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Globals are the flags every subcommand takes. They can be given before the subcommand, where
+// they apply to it, or after it along with its own flags
+type Globals struct {
+	Verbose        bool
+	Output         string // text, json or yaml
+	Keys           Files  // key files in any format the keys package reads
+	Certs          Files  // certificate files
+	JWKSURL        string
+	JWKSFile       string
+	HMACSecret     string
+	SecretFile     string // a file with the HMAC secret in it, or an oct JWK
+	SecretEnv      string // an environment variable with the HMAC secret in it
+	SecretEncoding string // how the HMAC secret is encoded: text, base64 or hex
+	DecryptKeys    Files  // private keys or oct JWKs to decrypt JWEs with
+}
+
+// NewGlobals gives the globals their defaults
+func NewGlobals() *Globals {
+	return &Globals{Output: "text", SecretEncoding: "text"}
+}
+
+// Register adds the global flags to a flag set. The current values are used as the defaults so
+// that registering them again for a subcommand keeps what was given before it
+func (g *Globals) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&g.Verbose, "verbose", g.Verbose, "Print more messages")
+	fs.StringVar(&g.Output, "output", g.Output, "Output format: text, json or yaml")
+	fs.Var(&g.Keys, "key", "A key in PEM, DER, PKCS#12, JWK or JWKS format, can be repeated")
+	fs.Var(&g.Certs, "cert", "An x509 certificate, its serial number is the kid, can be repeated")
+	fs.StringVar(&g.JWKSURL, "jwks-url", g.JWKSURL, "URL of a JWKS to get the keys from")
+	fs.StringVar(&g.JWKSFile, "jwks-file", g.JWKSFile, "A JWKS file to get the keys from")
+	fs.StringVar(&g.HMACSecret, "hmac-secret", g.HMACSecret, "Secret for HMAC signing or verification. It ends up in the shell history and ps, --hmac-secret-file and --hmac-secret-env don't")
+	fs.StringVar(&g.SecretFile, "hmac-secret-file", g.SecretFile, "A file with the HMAC secret in it, without the trailing newline, or an oct JWK")
+	fs.StringVar(&g.SecretEnv, "hmac-secret-env", g.SecretEnv, "An environment variable with the HMAC secret in it")
+	fs.StringVar(&g.SecretEncoding, "hmac-secret-encoding", g.SecretEncoding, "How the HMAC secret is encoded: text, base64 (standard or URL safe, with or without padding) or hex")
+	fs.Var(&g.DecryptKeys, "decrypt-key", "A private key, or an oct JWK for dir, to decrypt an encrypted token (JWE) with, can be repeated")
+}
+
+// Check reports globals with values that can't be used
+func (g *Globals) Check() error {
+	switch g.SecretEncoding {
+	case "text", "base64", "hex":
+	default:
+		return fmt.Errorf("unknown --hmac-secret-encoding %q, must be text, base64 or hex", g.SecretEncoding)
+	}
+	switch g.Output {
+	case "text", "json", "yaml":
+		return nil
+	}
+	return fmt.Errorf("unknown --output %q, must be text, json or yaml", g.Output)
+}
+
+// JSON reports whether --output json was asked for
+func (g *Globals) JSON() bool {
+	return g.Output == "json"
+}
+
+// Structured reports whether --output json or yaml was asked for
+func (g *Globals) Structured() bool {
+	return g.Output == "json" || g.Output == "yaml"
+}
+
+// Write writes v as --output asks, indented JSON or a YAML document
+func (g *Globals) Write(w io.Writer, v interface{}) error {
+	if g.Output == "yaml" {
+		return WriteYAML(w, v)
+	}
+	return WriteJSON(w, v)
+}
+
+// Logf prints a message to stderr when --verbose is given
+func (g *Globals) Logf(format string, args ...interface{}) {
+	if g.Verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// SigningKey loads the first --key. The first --cert, if there is one, gives the kid
+func (g *Globals) SigningKey() (*keys.Key, error) {
+	if len(g.Keys) == 0 {
+		return nil, errors.New("no --key given")
+	}
+	return g.signingKey(0)
+}
+
+// SigningKeys loads every --key. The --cert in the same place, if there is one, gives the kid
+func (g *Globals) SigningKeys() ([]*keys.Key, error) {
+	if len(g.Keys) == 0 {
+		return nil, errors.New("no --key given")
+	}
+	found := make([]*keys.Key, len(g.Keys))
+	for i := range g.Keys {
+		key, err := g.signingKey(i)
+		if err != nil {
+			return nil, err
+		}
+		found[i] = key
+	}
+	return found, nil
+}
+
+func (g *Globals) signingKey(i int) (*keys.Key, error) {
+	key, err := keys.LoadPrivateKey(g.Keys[i], "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", g.Keys[i], err)
+	}
+	if len(g.Certs) > i {
+		certs, err := keys.LoadCertificates(g.Certs[i], "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", g.Certs[i], err)
+		}
+		key.KeyID = certs[0].SerialNumber.String()
+		key.Certificates = certs
+	}
+	return key, nil
+}
+
+// Secret is the HMAC secret from --hmac-secret, --hmac-secret-file or --hmac-secret-env, decoded
+// as --hmac-secret-encoding says. It is nil when none of them are given
+func (g *Globals) Secret() ([]byte, error) {
+	given := 0
+	for _, flag := range []string{g.HMACSecret, g.SecretFile, g.SecretEnv} {
+		if flag != "" {
+			given++
+		}
+	}
+	switch {
+	case given == 0:
+		return nil, nil
+	case given > 1:
+		return nil, errors.New("only one of --hmac-secret, --hmac-secret-file and --hmac-secret-env can be given")
+	}
+	encoded := g.HMACSecret
+	if g.SecretFile != "" {
+		// an oct JWK has the secret in it already decoded
+		if secret, _, err := keys.LoadSecret(g.SecretFile); err == nil {
+			return secret, nil
+		}
+		data, err := os.ReadFile(g.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = strings.TrimRight(string(data), "\r\n")
+	}
+	if g.SecretEnv != "" {
+		value, ok := os.LookupEnv(g.SecretEnv)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("--hmac-secret-env %s is not set", g.SecretEnv)
+		}
+		encoded = value
+	}
+	switch g.SecretEncoding {
+	case "base64":
+		// standard or URL safe, padded or not
+		encoded = strings.TrimRight(strings.NewReplacer("-", "+", "_", "/").Replace(encoded), "=")
+		secret, err := base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("the HMAC secret is not base64: %w", err)
+		}
+		return secret, nil
+	case "hex":
+		secret, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("the HMAC secret is not hex: %w", err)
+		}
+		return secret, nil
+	}
+	return []byte(encoded), nil
+}
+
+// KeySource is every key the globals name, for verifying with. The --jwks-url is fetched with the
+// client
+func (g *Globals) KeySource(client *http.Client) (jwttools.KeySource, error) {
+	var sources jwttools.KeySources
+	secret, err := g.Secret()
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		// the secret is whatever the issuer chose, so a short one is only a warning here
+		if err := jwttools.CheckSecret("HS256", secret); err != nil {
+			Warning(err)
+		}
+		sources = append(sources, jwttools.HMACSecret(secret))
+	}
+	if g.JWKSURL != "" {
+		sources = append(sources, jwttools.JWKSURL{URL: g.JWKSURL, Client: client})
+	}
+	if g.JWKSFile != "" {
+		sources = append(sources, jwttools.JWKSFile(g.JWKSFile))
+	}
+	var static jwttools.StaticKeys
+	for _, file := range append(append(Files{}, g.Keys...), g.Certs...) {
+		found, err := keys.Load(file, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		static = append(static, found...)
+	}
+	if len(static) > 0 {
+		sources = append(sources, static)
+	}
+	if len(sources) == 0 {
+		return nil, ErrNoKeys
+	}
+	return sources, nil
+}
+
+// DecryptionKeys loads the --decrypt-key files. A file with a symmetric (oct) JWK gives a secret
+// for dir, anything else has to have a private key in it
+func (g *Globals) DecryptionKeys() ([]interface{}, error) {
+	var found []interface{}
+	for _, file := range g.DecryptKeys {
+		key, err := keys.LoadPrivateKey(file, "")
+		if err == nil {
+			found = append(found, key.Private)
+			continue
+		}
+		if !errors.Is(err, keys.ErrUnsupportedKey) {
+			return nil, err
+		}
+		secret, _, secretErr := keys.LoadSecret(file)
+		if secretErr != nil {
+			return nil, err
+		}
+		found = append(found, secret)
+	}
+	return found, nil
+}
+
+// WriteJSON writes v as indented JSON
+func WriteJSON(w io.Writer, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// WriteYAML writes v as a YAML document. It goes through JSON so the names and order are the
+// same as WriteJSON's, and starts with --- so several can be written one after another
+func WriteYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	blockStyle(&node)
+	if _, err := io.WriteString(w, "---\n"); err != nil {
+		return err
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// blockStyle undoes the flow style and quoting the node got from being read as JSON
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// Usage sets the usage message of a subcommand's flag set
+func Usage(fs *flag.FlagSet, lines ...string) {
+	fs.Usage = func() {
+		for _, line := range lines {
+			fmt.Fprintln(fs.Output(), line)
+		}
+		fs.PrintDefaults()
+	}
+}
+
+// Fatal prints an error to stderr, leaving stdout for the output. The caller returns the exit code
+func Fatal(args ...interface{}) {
+	fmt.Fprint(os.Stderr, "[FATAL]")
+	fmt.Fprintln(os.Stderr, args...)
+}
+
+// Warning prints a warning to stderr
+func Warning(args ...interface{}) {
+	fmt.Fprint(os.Stderr, "[WARNING]")
+	fmt.Fprintln(os.Stderr, args...)
+}
+
+// ReadToken gets the token from --token, the first argument or stdin, in that order. A token of
+// - is read from stdin too. Surrounding whitespace and a "Bearer " prefix are removed
+func ReadToken(token string, args []string) (string, error) {
+	if token == "" && len(args) > 0 {
+		token = args[0]
+	}
+	if token == "" || token == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		token = string(data)
+	}
+	token = strings.TrimSpace(token)
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if token == "" {
+		return "", errors.New("no token given")
+	}
+	return token, nil
+}
+
+// SortedKeys gives the keys of a claims or header map in order, for printing
+func SortedKeys(m map[string]interface{}) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FlagExit is the exit code for an error from parsing the flags, -h is not an error
+func FlagExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return ExitError
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	text := write("secret.txt", "secret\n")
+	encoded := write("secret.b64", "c2VjcmV0\r\n")
+	oct := write("secret.json", `{"kty":"oct","k":"c2VjcmV0","kid":"s1"}`)
+	t.Setenv("JWT_TOOLS_TEST_SECRET", "736563726574")
+
+	tests := []struct {
+		name string
+		g    Globals
+		want string
+	}{
+		{"none", Globals{}, ""},
+		{"text", Globals{HMACSecret: "secret"}, "secret"},
+		{"base64", Globals{HMACSecret: "c2VjcmV0", SecretEncoding: "base64"}, "secret"},
+		{"base64 padded", Globals{HMACSecret: "c2VjcmV0Pz8=", SecretEncoding: "base64"}, "secret??"},
+		{"base64 URL safe", Globals{HMACSecret: "-_8", SecretEncoding: "base64"}, "\xfb\xff"},
+		{"base64 standard", Globals{HMACSecret: "+/8=", SecretEncoding: "base64"}, "\xfb\xff"},
+		{"hex", Globals{HMACSecret: "736563726574", SecretEncoding: "hex"}, "secret"},
+		{"file", Globals{SecretFile: text}, "secret"},
+		{"encoded file", Globals{SecretFile: encoded, SecretEncoding: "base64"}, "secret"},
+		{"oct JWK", Globals{SecretFile: oct, SecretEncoding: "hex"}, "secret"},
+		{"env", Globals{SecretEnv: "JWT_TOOLS_TEST_SECRET", SecretEncoding: "hex"}, "secret"},
+	}
+	for _, test := range tests {
+		secret, err := test.g.Secret()
+		if err != nil || string(secret) != test.want {
+			t.Errorf("%s: got %q, %v, want %q", test.name, secret, err, test.want)
+		}
+	}
+
+	for name, g := range map[string]Globals{
+		"two":         {HMACSecret: "secret", SecretEnv: "JWT_TOOLS_TEST_SECRET"},
+		"not base64":  {HMACSecret: "secret!", SecretEncoding: "base64"},
+		"not hex":     {HMACSecret: "secret", SecretEncoding: "hex"},
+		"no file":     {SecretFile: filepath.Join(dir, "missing")},
+		"env not set": {SecretEnv: "JWT_TOOLS_TEST_UNSET"},
+	} {
+		if secret, err := g.Secret(); err == nil {
+			t.Errorf("%s: got %q", name, secret)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Package decode is the decode subcommand. It prints the header and claims of a JWT without
+// verifying it, like the jwt-decode script
+package decode
+
+import (
+	"flag"
+	"os"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// Main runs the subcommand and returns the exit code
+func Main(g *cli.Globals, args []string) int {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	g.Register(fs)
+	token := fs.String("token", "", "JWT token to decode, read from the first argument or stdin if not given")
+	cli.Usage(fs, "Usage: jwt-tools decode [--decrypt-key key.pem] [--token] <token>",
+		"       echo $JWT | jwt-tools decode")
+	if err := fs.Parse(args); err != nil {
+		return cli.FlagExit(err)
+	}
+	if err := g.Check(); err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+	tokenString, err := cli.ReadToken(*token, fs.Args())
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+	decryptionKeys, err := g.DecryptionKeys()
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+	result, err := jwttools.Decode(tokenString, decryptionKeys...)
+	if err != nil {
+		cli.Fatal("Unable to decode token: ", err)
+		return cli.ExitRejected
+	}
+	if result.Encryption != nil && result.Claims == nil {
+		cli.Warning("The token is encrypted, give --decrypt-key to see the claims")
+	}
+	if g.Structured() {
+		out := map[string]interface{}{"header": result.Header, "claims": result.Claims}
+		if result.Encryption != nil {
+			out["encryption"] = result.Encryption
+		}
+		g.Write(os.Stdout, out)
+		return cli.ExitOK
+	}
+	// the same as jwt-decode, the header then the claims. An encrypted token has its JWE header
+	// first, and no JWS header if it isn't signed
+	for _, part := range []map[string]interface{}{result.Encryption, result.Header, result.Claims} {
+		if part != nil {
+			cli.WriteJSON(os.Stdout, part)
+		}
+	}
+	return cli.ExitOK
+}
@@ -0,0 +1,183 @@
+package jwks
+
+/* jwt-tools jwks diff a.json b.json compares two JWKS documents key by key.
+   Keys are paired by kid, and any left over are paired by their key material so that a key
+   whose kid has changed shows up as a kid difference rather than as one removed and one added
+*/
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"os"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// keyMaterial describes what the key is, for RSA the modulus
+func keyMaterial(j jwk.Key) string {
+	var raw interface{}
+	if pub, err := jwk.PublicKeyOf(j); err == nil {
+		pub.Raw(&raw)
+	}
+	switch key := raw.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("modulus %x", key.N)
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("%s point %x,%x", key.Curve.Params().Name, key.X, key.Y)
+	case ed25519.PublicKey:
+		return fmt.Sprintf("Ed25519 key %x", []byte(key))
+	}
+	return fmt.Sprintf("%s key", j.KeyType())
+}
+
+// sameKeyMaterial compares the public halves of the keys
+func sameKeyMaterial(a, b jwk.Key) bool {
+	pa, errA := jwk.PublicKeyOf(a)
+	pb, errB := jwk.PublicKeyOf(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	ta, errA := pa.Thumbprint(crypto.SHA256)
+	tb, errB := pb.Thumbprint(crypto.SHA256)
+	return errA == nil && errB == nil && bytes.Equal(ta, tb)
+}
+
+// sameCertificates compares the x5c chains
+func sameCertificates(a, b jwk.Key) bool {
+	certsA, _ := jwttools.Certificates(a)
+	certsB, _ := jwttools.Certificates(b)
+	if len(certsA) != len(certsB) {
+		return false
+	}
+	for i := range certsA {
+		if !bytes.Equal(certsA[i].Raw, certsB[i].Raw) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareKeys returns a line for each way the two keys differ
+func compareKeys(a, b jwk.Key) []string {
+	var diffs []string
+	if a.KeyID() != b.KeyID() {
+		diffs = append(diffs, fmt.Sprintf("kid: %q -> %q", a.KeyID(), b.KeyID()))
+	}
+	if a.Algorithm().String() != b.Algorithm().String() {
+		diffs = append(diffs, fmt.Sprintf("alg: %q -> %q", a.Algorithm(), b.Algorithm()))
+	}
+	if a.KeyUsage() != b.KeyUsage() {
+		diffs = append(diffs, fmt.Sprintf("use: %q -> %q", a.KeyUsage(), b.KeyUsage()))
+	}
+	if !sameKeyMaterial(a, b) {
+		diffs = append(diffs, fmt.Sprintf("key: %s -> %s", keyMaterial(a), keyMaterial(b)))
+	}
+	if !sameCertificates(a, b) {
+		certsA, _ := jwttools.Certificates(a)
+		certsB, _ := jwttools.Certificates(b)
+		diffs = append(diffs, fmt.Sprintf("x5c: %d certificate(s) -> %d certificate(s), contents differ", len(certsA), len(certsB)))
+	}
+	return diffs
+}
+
+// loadJWKSFile reads either a single JWK or a JWKS
+func loadJWKSFile(filename string) ([]jwk.Key, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	set, err := jwk.Parse(bytes.TrimSpace(data))
+	if err != nil {
+		return nil, err
+	}
+	found := make([]jwk.Key, set.Len())
+	for i := range found {
+		found[i], _ = set.Key(i)
+	}
+	return found, nil
+}
+
+// diffJWKS prints the differences between the two files and returns how many there were
+func diffJWKS(fileA, fileB string) (int, error) {
+	keysA, err := loadJWKSFile(fileA)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", fileA, err)
+	}
+	keysB, err := loadJWKSFile(fileB)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", fileB, err)
+	}
+
+	matchedB := make([]bool, len(keysB))
+	pairs := make([]int, len(keysA))
+	for i := range pairs {
+		pairs[i] = -1
+	}
+	// first by kid
+	for i, a := range keysA {
+		for j, b := range keysB {
+			if !matchedB[j] && a.KeyID() != "" && a.KeyID() == b.KeyID() {
+				pairs[i] = j
+				matchedB[j] = true
+				break
+			}
+		}
+	}
+	// then by key material
+	for i, a := range keysA {
+		if pairs[i] >= 0 {
+			continue
+		}
+		for j, b := range keysB {
+			if !matchedB[j] && sameKeyMaterial(a, b) {
+				pairs[i] = j
+				matchedB[j] = true
+				break
+			}
+		}
+	}
+
+	count := 0
+	for i, a := range keysA {
+		if pairs[i] < 0 {
+			fmt.Printf("- kid %q only in %s\n", a.KeyID(), fileA)
+			count++
+			continue
+		}
+		for _, diff := range compareKeys(a, keysB[pairs[i]]) {
+			fmt.Printf("~ kid %q %s\n", a.KeyID(), diff)
+			count++
+		}
+	}
+	for j, b := range keysB {
+		if !matchedB[j] {
+			fmt.Printf("+ kid %q only in %s\n", b.KeyID(), fileB)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// runDiff is the diff subcommand. Like diff(1) it exits 0 when the files are the same, 1 when
+// they differ and 2 when they can't be compared
+func runDiff(args []string) int {
+	if len(args) != 2 {
+		cli.Fatal("Usage: jwt-tools jwks diff <a.json> <b.json>")
+		return cli.ExitError
+	}
+	count, err := diffJWKS(args[0], args[1])
+	if err != nil {
+		cli.Fatal("Unable to compare: ", err)
+		return cli.ExitError
+	}
+	if count > 0 {
+		return cli.ExitRejected
+	}
+	return cli.ExitOK
+}
@@ -0,0 +1,182 @@
+/*
+Package jwks is the jwks subcommand, formerly mk-jwks. It will produce a JWKS from any certificate
+or key supported by golang's standard crypto library. It will give an error for any unsupported
+certificate types passed to it, but continue and use supported ones
+
+Certificates get their serial number as the kid. Bare keys, which have no serial number, get their
+RFC 7638 thumbprint
+
+With -private it reads private keys instead and produces a private JWKS suitable for signing.
+Certificates given alongside the keys are matched to them so that the kid and x5c agree with
+the public JWKS made from the same certificates
+*/
+package jwks
+
+import (
+	"crypto"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// publicJWKS builds the public JWKS from the keys and certificates in each file. Any file that a
+// public key can be had from is accepted, in the same way as python's JsonWebKey.import_key:
+// certificates, public keys, private keys (only the public half is used), JWKs and OpenSSH public
+// keys. When a file has certificates in it the first is used as the signing certificate and the
+// rest as its chain
+func publicJWKS(files []string) jwk.Set {
+	jwks := jwk.NewSet()
+	for _, certFile := range files {
+		found, err := keys.Load(certFile, "")
+		if err != nil {
+			cli.Warning("Cannot parse "+certFile+", skipping: ", err)
+			continue
+		}
+		for _, key := range found {
+			j, err := jwttools.BuildJWK(key, jwttools.JWKSOptions{})
+			if err != nil {
+				cli.Warning("Cannot use a key in "+certFile+", skipping: ", err)
+				continue
+			}
+			jwks.AddKey(j)
+		}
+	}
+	return jwks
+}
+
+// privateJWKS builds a private JWKS from every private key found in the files.
+// Certificates in the files are matched to the keys by their public key, so that a key with a
+// certificate gets the serial number as its kid just like the public JWKS. Keys without a
+// certificate use their RFC 7638 thumbprint as the kid
+func privateJWKS(files []string) jwk.Set {
+	jwks := jwk.NewSet()
+	var privateKeys, certKeys []*keys.Key
+	for _, keyFile := range files {
+		found, err := keys.Load(keyFile, "")
+		if err != nil {
+			cli.Warning("Cannot parse "+keyFile+", skipping: ", err)
+			continue
+		}
+		for _, key := range found {
+			if key.Private != nil {
+				privateKeys = append(privateKeys, key)
+			} else if len(key.Certificates) > 0 {
+				certKeys = append(certKeys, key)
+			}
+		}
+	}
+
+	for _, key := range privateKeys {
+		// the certificate may have been in a different file to the key
+		if len(key.Certificates) == 0 {
+			for _, certKey := range certKeys {
+				if pub, ok := certKey.Public.(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(key.Public) {
+					key.Certificates = certKey.Certificates
+					if key.KeyID == "" {
+						key.KeyID = certKey.KeyID
+					}
+					break
+				}
+			}
+		}
+		j, err := jwttools.BuildJWK(key, jwttools.JWKSOptions{Private: true})
+		if err != nil {
+			cli.Warning("Cannot use private key, skipping: ", err)
+			continue
+		}
+		jwks.AddKey(j)
+	}
+	return jwks
+}
+
+// Main runs the subcommand and returns the exit code
+func Main(g *cli.Globals, args []string) int {
+	if len(args) > 0 && args[0] == "diff" {
+		return runDiff(args[1:])
+	}
+
+	fs := flag.NewFlagSet("jwks", flag.ContinueOnError)
+	g.Register(fs)
+	private := fs.Bool("private", false, "Create a private JWKS from private key PEM files. Requires --out")
+	outFile := fs.String("out", "", "Write the output to this file instead of stdout. The file is replaced atomically")
+	pretty := fs.Bool("pretty", false, "Indent the JSON output")
+	format := fs.String("format", "jwks", "Output format: jwks, jwk-per-line or pem-bundle")
+	k8sKind := fs.String("k8s", "", "Wrap the output in a Kubernetes manifest: configmap or secret")
+	k8sName := fs.String("k8s-name", "jwks", "metadata.name of the Kubernetes manifest")
+	k8sNamespace := fs.String("k8s-namespace", "", "metadata.namespace of the Kubernetes manifest")
+	k8sKey := fs.String("k8s-key", "", "The data key in the Kubernetes manifest (default depends on --format)")
+	cli.Usage(fs, "Usage: jwt-tools jwks [options] <cert1.pem> [cert2.pem] ...",
+		"       jwt-tools jwks --private --out jwks.json [options] <key1.pem> [key2.pem] [cert1.pem] ...",
+		"       jwt-tools jwks diff <a.json> <b.json>")
+	if err := fs.Parse(args); err != nil {
+		return cli.FlagExit(err)
+	}
+	// the global --key and --cert files are used along with the arguments
+	files := append(append(fs.Args(), g.Keys...), g.Certs...)
+
+	// Check that at least one certificate file is provided
+	if len(files) < 1 {
+		cli.Fatal("At least one certificate file must be provided")
+		fs.Usage()
+		return cli.ExitError
+	}
+
+	// Private keys never go to stdout
+	if *private && *outFile == "" {
+		cli.Fatal("--private requires --out so the keys can be written to a file with 0600 permissions")
+		return cli.ExitError
+	}
+	if *private && *k8sKind == "configmap" {
+		cli.Fatal("Private keys can't be put in a ConfigMap, use --k8s secret")
+		return cli.ExitError
+	}
+
+	// Check that all provided certificate files exist
+	for _, certFile := range files {
+		if _, err := os.Stat(certFile); os.IsNotExist(err) {
+			cli.Fatal("Certificate file does not exist: " + certFile)
+			return cli.ExitError
+		}
+	}
+
+	var jwks jwk.Set
+	if *private {
+		jwks = privateJWKS(files)
+	} else {
+		jwks = publicJWKS(files)
+	}
+	output, err := render(jwks, *format, *pretty)
+	if err != nil {
+		cli.Fatal("Unable to produce "+*format+": ", err)
+		return cli.ExitError
+	}
+	if *k8sKind != "" {
+		if *k8sKey == "" {
+			*k8sKey = defaultDataKey(*format)
+		}
+		output, err = k8sManifest(*k8sKind, *k8sName, *k8sNamespace, *k8sKey, output)
+		if err != nil {
+			cli.Fatal("Unable to produce Kubernetes manifest: ", err)
+			return cli.ExitError
+		}
+	}
+	if *outFile == "" {
+		fmt.Print(string(output))
+		return cli.ExitOK
+	}
+	if *private {
+		err = writeFileAtomic(*outFile, output, 0600, true)
+	} else {
+		err = writeFileAtomic(*outFile, output, 0644, false)
+	}
+	if err != nil {
+		cli.Fatal("Unable to write "+*outFile+": ", err)
+		return cli.ExitError
+	}
+	return cli.ExitOK
+}
@@ -0,0 +1,173 @@
+package jwks
+
+/* The different ways the jwks subcommand can write out the keys it has collected */
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// render turns the JWKS into the requested output format
+func render(jwks jwk.Set, format string, pretty bool) ([]byte, error) {
+	switch format {
+	case "jwks":
+		var out []byte
+		var err error
+		if pretty {
+			out, err = json.MarshalIndent(jwks, "", "  ")
+		} else {
+			out, err = json.Marshal(jwks)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	case "jwk-per-line":
+		// one compact JWK per line, pretty printing would defeat the point
+		var out bytes.Buffer
+		for i := 0; i < jwks.Len(); i++ {
+			key, _ := jwks.Key(i)
+			line, err := json.Marshal(key)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(line)
+			out.WriteByte('\n')
+		}
+		return out.Bytes(), nil
+	case "pem-bundle":
+		return pemBundle(jwks)
+	}
+	return nil, fmt.Errorf("unknown format %q, must be one of jwks, jwk-per-line or pem-bundle", format)
+}
+
+// pemBundle writes each key as PEM. Private keys are written as PKCS8 followed by their certificate.
+// Public keys are written as their certificate chain, or as a PUBLIC KEY when there isn't one
+func pemBundle(jwks jwk.Set) ([]byte, error) {
+	var out bytes.Buffer
+	for i := 0; i < jwks.Len(); i++ {
+		key, _ := jwks.Key(i)
+		var raw interface{}
+		if err := key.Raw(&raw); err != nil {
+			return nil, fmt.Errorf("kid %s: %v", key.KeyID(), err)
+		}
+		certs, err := jwttools.Certificates(key)
+		if err != nil {
+			return nil, fmt.Errorf("kid %s: %v", key.KeyID(), err)
+		}
+		if private, _ := jwk.IsPrivateKey(key); private {
+			der, err := x509.MarshalPKCS8PrivateKey(raw)
+			if err != nil {
+				return nil, fmt.Errorf("kid %s: %v", key.KeyID(), err)
+			}
+			pem.Encode(&out, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		} else if len(certs) == 0 {
+			der, err := x509.MarshalPKIXPublicKey(raw)
+			if err != nil {
+				return nil, fmt.Errorf("kid %s: %v", key.KeyID(), err)
+			}
+			pem.Encode(&out, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		}
+		for _, cert := range certs {
+			pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// defaultDataKey is the name the output gets inside a ConfigMap or Secret
+func defaultDataKey(format string) string {
+	switch format {
+	case "jwk-per-line":
+		return "jwks.jsonl"
+	case "pem-bundle":
+		return "keys.pem"
+	}
+	return "jwks.json"
+}
+
+// k8sManifest wraps the output in a ConfigMap or Secret
+func k8sManifest(kind, name, namespace, dataKey string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("apiVersion: v1\n")
+	switch kind {
+	case "configmap":
+		out.WriteString("kind: ConfigMap\n")
+	case "secret":
+		out.WriteString("kind: Secret\n")
+	default:
+		return nil, fmt.Errorf("unknown Kubernetes kind %q, must be configmap or secret", kind)
+	}
+	out.WriteString("metadata:\n")
+	fmt.Fprintf(&out, "  name: %s\n", yamlString(name))
+	if namespace != "" {
+		fmt.Fprintf(&out, "  namespace: %s\n", yamlString(namespace))
+	}
+	if kind == "secret" {
+		out.WriteString("type: Opaque\n")
+		out.WriteString("data:\n")
+		fmt.Fprintf(&out, "  %s: %s\n", yamlString(dataKey), base64.StdEncoding.EncodeToString(data))
+		return out.Bytes(), nil
+	}
+	out.WriteString("data:\n")
+	fmt.Fprintf(&out, "  %s: |\n", yamlString(dataKey))
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		out.WriteString("    " + line + "\n")
+	}
+	return out.Bytes(), nil
+}
+
+// yamlString quotes a scalar if it contains anything YAML might interpret
+func yamlString(s string) string {
+	if s == "" || strings.ContainsAny(s, ":#{}[],&*!|>'\"%@` \t") {
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	}
+	return s
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it over
+// filename, so a reader never sees a half written file. When private is set an existing file with
+// permissions other than 0600 is refused rather than replaced
+func writeFileAtomic(filename string, data []byte, perm os.FileMode, private bool) error {
+	if info, err := os.Stat(filename); err == nil {
+		if private && info.Mode().Perm() != 0600 {
+			return fmt.Errorf("%s has permissions %#o, private keys must only be written to a file with permissions 0600", filename, info.Mode().Perm())
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// clean up if anything goes wrong before the rename
+	defer os.Remove(tmp.Name())
+	// CreateTemp uses 0600, so set what was asked for
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,405 @@
+// Package keygen is the keys subcommand, formerly mk-keys. It generates key pairs and certificates
+// for testing the other subcommands with, without needing openssl. Keys are written as PKCS8 PEM and
+// certificates as PEM with the issuing CA appended, which is what mint (--key and --cert) and jwks
+// (the certificate files) expect
+package keygen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+var (
+	oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+	defaultSubject  = "/C=UK/ST=Scotland/L=Edinburgh/O=Home/OU=Garage/CN=localhost/emailAddress=bilbo@baggins.com"
+	keyUsageNames   = map[string]x509.KeyUsage{
+		"digitalSignature":  x509.KeyUsageDigitalSignature,
+		"contentCommitment": x509.KeyUsageContentCommitment,
+		"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+		"dataEncipherment":  x509.KeyUsageDataEncipherment,
+		"keyAgreement":      x509.KeyUsageKeyAgreement,
+		"keyCertSign":       x509.KeyUsageCertSign,
+		"cRLSign":           x509.KeyUsageCRLSign,
+	}
+	extKeyUsageNames = map[string]x509.ExtKeyUsage{
+		"serverAuth":      x509.ExtKeyUsageServerAuth,
+		"clientAuth":      x509.ExtKeyUsageClientAuth,
+		"codeSigning":     x509.ExtKeyUsageCodeSigning,
+		"emailProtection": x509.ExtKeyUsageEmailProtection,
+		"timeStamping":    x509.ExtKeyUsageTimeStamping,
+		"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+	}
+)
+
+// keySpec is one kind of key that can be generated
+type keySpec struct {
+	name     string // used in the file names, matching the names genCerts used
+	generate func() (crypto.Signer, error)
+}
+
+// allKeySpecs is every key type the keys subcommand knows about
+var allKeySpecs = map[string]keySpec{
+	"rsa2048": {"rsa-2048", func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) }},
+	"rsa3072": {"rsa-3072", func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 3072) }},
+	"rsa4096": {"rsa-4096", func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 4096) }},
+	"P-256":   {"ecdsa-prime256v1", func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }},
+	"P-384":   {"ecdsa-secp384r1", func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) }},
+	"P-521":   {"ecdsa-secp521r1", func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P521(), rand.Reader) }},
+	"ed25519": {"ed25519", func() (crypto.Signer, error) { _, key, err := ed25519.GenerateKey(rand.Reader); return key, err }},
+}
+
+// allKeyOrder is the order --all generates them in
+var allKeyOrder = []string{"rsa2048", "rsa3072", "rsa4096", "P-256", "P-384", "P-521", "ed25519"}
+
+// lookupKeySpec turns the --type, --bits and --curve options into a keySpec
+func lookupKeySpec(keyType string, bits int, curve string) (keySpec, error) {
+	switch strings.ToLower(keyType) {
+	case "rsa":
+		if spec, ok := allKeySpecs[fmt.Sprintf("rsa%d", bits)]; ok {
+			return spec, nil
+		}
+		return keySpec{}, fmt.Errorf("unsupported RSA key size %d, must be 2048, 3072 or 4096", bits)
+	case "ec", "ecdsa":
+		// accept the openssl names too
+		switch curve {
+		case "prime256v1", "secp256r1":
+			curve = "P-256"
+		case "secp384r1":
+			curve = "P-384"
+		case "secp521r1":
+			curve = "P-521"
+		}
+		switch curve = strings.ToUpper(curve); curve {
+		case "P-256", "P-384", "P-521":
+			return allKeySpecs[curve], nil
+		}
+		return keySpec{}, fmt.Errorf("unsupported curve %s, must be P-256, P-384 or P-521", curve)
+	case "ed25519":
+		return allKeySpecs["ed25519"], nil
+	}
+	return keySpec{}, fmt.Errorf("unsupported key type %s, must be rsa, ec or ed25519", keyType)
+}
+
+// parseSubject parses an openssl style subject such as /C=UK/O=Home/CN=localhost
+func parseSubject(subject string) (pkix.Name, error) {
+	var name pkix.Name
+	for _, part := range strings.Split(strings.Trim(subject, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return name, fmt.Errorf("invalid subject component %q, expecting key=value", part)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "CN":
+			name.CommonName = value
+		case "emailAddress":
+			name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{Type: oidEmailAddress, Value: value})
+		default:
+			return name, fmt.Errorf("unsupported subject component %q", key)
+		}
+	}
+	return name, nil
+}
+
+// addSANs parses openssl style subjectAltName entries such as DNS:localhost,IP:127.0.0.1 into the template
+func addSANs(template *x509.Certificate, sans string) error {
+	for _, san := range strings.Split(sans, ",") {
+		san = strings.TrimSpace(san)
+		if san == "" {
+			continue
+		}
+		kv := strings.SplitN(san, ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid SAN %q, expecting type:value", san)
+		}
+		switch strings.ToUpper(kv[0]) {
+		case "DNS":
+			template.DNSNames = append(template.DNSNames, kv[1])
+		case "IP":
+			ip := net.ParseIP(kv[1])
+			if ip == nil {
+				return fmt.Errorf("invalid IP address in SAN %q", san)
+			}
+			template.IPAddresses = append(template.IPAddresses, ip)
+		case "EMAIL":
+			template.EmailAddresses = append(template.EmailAddresses, kv[1])
+		case "URI":
+			uri, err := url.Parse(kv[1])
+			if err != nil {
+				return fmt.Errorf("invalid URI in SAN %q: %v", san, err)
+			}
+			template.URIs = append(template.URIs, uri)
+		default:
+			return fmt.Errorf("unsupported SAN type %q, must be DNS, IP, email or URI", kv[0])
+		}
+	}
+	return nil
+}
+
+// parseKeyUsage parses a comma separated list of key usage names
+func parseKeyUsage(usages string) (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	var keyUsage x509.KeyUsage
+	var extKeyUsage []x509.ExtKeyUsage
+	for _, usage := range strings.Split(usages, ",") {
+		usage = strings.TrimSpace(usage)
+		if usage == "" {
+			continue
+		}
+		if ku, ok := keyUsageNames[usage]; ok {
+			keyUsage |= ku
+		} else if eku, ok := extKeyUsageNames[usage]; ok {
+			extKeyUsage = append(extKeyUsage, eku)
+		} else {
+			return 0, nil, fmt.Errorf("unknown key usage %q", usage)
+		}
+	}
+	return keyUsage, extKeyUsage, nil
+}
+
+// randomSerial gives a 128 bit serial number. mint and jwks use the serial as the kid so it needs to be unique
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// loadCA reads the certificate and private key of the CA that will sign the new certificate
+func loadCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certs, err := keys.LoadCertificates(certFile, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := keys.LoadPrivateKey(keyFile, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return certs[0], key.Private, nil
+}
+
+// writePEM writes the blocks to a file with the given permissions
+func writePEM(filename string, perm os.FileMode, blocks ...*pem.Block) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := pem.Encode(f, block); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// certOptions are the parts of the certificate that come from the command line
+type certOptions struct {
+	subject     pkix.Name
+	template    x509.Certificate // only the SANs are used
+	validity    time.Duration
+	keyUsage    x509.KeyUsage
+	extKeyUsage []x509.ExtKeyUsage
+	isCA        bool
+	caCert      *x509.Certificate
+	caKey       crypto.Signer
+}
+
+// makeKeyPair generates the key and, unless noCert is set, the certificate and writes them to
+// <outDir>/<name>-key.pem and <outDir>/<name>-certificate.pem. It returns the files written
+func makeKeyPair(g *cli.Globals, spec keySpec, name, outDir string, noCert bool, opts certOptions) ([]string, error) {
+	key, err := spec.generate()
+	if err != nil {
+		return nil, fmt.Errorf("generating %s key: %v", spec.name, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := filepath.Join(outDir, name+"-key.pem")
+	if err := writePEM(keyFile, 0600, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}); err != nil {
+		return nil, err
+	}
+	if noCert {
+		return []string{keyFile}, nil
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               opts.subject,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(opts.validity),
+		KeyUsage:              opts.keyUsage,
+		ExtKeyUsage:           opts.extKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  opts.isCA,
+		DNSNames:              opts.template.DNSNames,
+		IPAddresses:           opts.template.IPAddresses,
+		EmailAddresses:        opts.template.EmailAddresses,
+		URIs:                  opts.template.URIs,
+	}
+	// self signed unless there's a CA
+	parent, signer := template, key
+	if opts.caCert != nil {
+		parent, signer = opts.caCert, opts.caKey
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s certificate: %v", spec.name, err)
+	}
+	blocks := []*pem.Block{{Type: "CERTIFICATE", Bytes: certDER}}
+	// append the CA so jwks puts the chain in the x5c
+	if opts.caCert != nil {
+		blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Bytes: opts.caCert.Raw})
+	}
+	certFile := filepath.Join(outDir, name+"-certificate.pem")
+	if err := writePEM(certFile, 0644, blocks...); err != nil {
+		return nil, err
+	}
+	g.Logf("%s: serial %s, valid until %s", certFile, serial.String(), template.NotAfter.Format(time.RFC3339))
+	return []string{keyFile, certFile}, nil
+}
+
+// Main runs the subcommand and returns the exit code
+func Main(g *cli.Globals, args []string) int {
+	fs := flag.NewFlagSet("keys", flag.ContinueOnError)
+	g.Register(fs)
+	keyType := fs.String("type", "rsa", "Key type: rsa, ec or ed25519")
+	bits := fs.Int("bits", 2048, "RSA key size: 2048, 3072 or 4096")
+	curve := fs.String("curve", "P-256", "EC curve: P-256, P-384 or P-521 (or the openssl names)")
+	all := fs.Bool("all", false, "Generate every key type, ignoring --type, --bits, --curve and --name")
+	name := fs.String("name", "", "Base name of the files written (default depends on the key type, e.g. ecdsa-prime256v1)")
+	outDir := fs.String("out-dir", "certs", "Directory to write the keys and certificates to")
+	noCert := fs.Bool("no-cert", false, "Only generate the key, no certificate")
+	subject := fs.String("subject", defaultSubject, "Certificate subject in openssl format")
+	san := fs.String("san", "", "Comma separated subjectAltNames, e.g. DNS:localhost,IP:127.0.0.1,email:a@b.com,URI:https://example.com")
+	days := fs.Int("days", 3650, "Number of days the certificate is valid for")
+	usage := fs.String("key-usage", "", "Comma separated key usages, e.g. digitalSignature,keyEncipherment,serverAuth (default digitalSignature, or keyCertSign,cRLSign,digitalSignature with --ca)")
+	isCA := fs.Bool("ca", false, "Make the certificate a CA that can sign other certificates")
+	caCertFile := fs.String("ca-cert", "", "Sign the certificate with this CA certificate instead of self signing")
+	caKeyFile := fs.String("ca-key", "", "The private key of --ca-cert")
+	cli.Usage(fs, "Usage: jwt-tools keys [--type rsa|ec|ed25519] [--bits n] [--curve P-256] [--all] [--out-dir certs] [options]")
+	if err := fs.Parse(args); err != nil {
+		return cli.FlagExit(err)
+	}
+	if err := g.Check(); err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+
+	if (*caCertFile == "") != (*caKeyFile == "") {
+		cli.Fatal("--ca-cert and --ca-key must be given together")
+		return cli.ExitError
+	}
+	if *all && *name != "" {
+		cli.Fatal("--name can't be used with --all")
+		return cli.ExitError
+	}
+
+	var opts certOptions
+	var err error
+	if opts.subject, err = parseSubject(*subject); err != nil {
+		cli.Fatal("Invalid subject: ", err)
+		return cli.ExitError
+	}
+	if err = addSANs(&opts.template, *san); err != nil {
+		cli.Fatal("Invalid SAN: ", err)
+		return cli.ExitError
+	}
+	if *usage == "" {
+		*usage = "digitalSignature"
+		if *isCA {
+			*usage = "keyCertSign,cRLSign,digitalSignature"
+		}
+	}
+	if opts.keyUsage, opts.extKeyUsage, err = parseKeyUsage(*usage); err != nil {
+		cli.Fatal("Invalid key usage: ", err)
+		return cli.ExitError
+	}
+	if *days <= 0 {
+		cli.Fatal("--days must be positive")
+		return cli.ExitError
+	}
+	opts.validity = time.Duration(*days) * 24 * time.Hour
+	opts.isCA = *isCA
+	if *caCertFile != "" {
+		if opts.caCert, opts.caKey, err = loadCA(*caCertFile, *caKeyFile); err != nil {
+			cli.Fatal("Unable to load CA: ", err)
+			return cli.ExitError
+		}
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		cli.Fatal("Unable to create "+*outDir+": ", err)
+		return cli.ExitError
+	}
+
+	var specs []keySpec
+	if *all {
+		for _, k := range allKeyOrder {
+			specs = append(specs, allKeySpecs[k])
+		}
+	} else {
+		spec, err := lookupKeySpec(*keyType, *bits, *curve)
+		if err != nil {
+			cli.Fatal(err)
+			return cli.ExitError
+		}
+		specs = append(specs, spec)
+	}
+	written := []string{}
+	for _, spec := range specs {
+		fileName := spec.name
+		if *name != "" {
+			fileName = *name
+		}
+		files, err := makeKeyPair(g, spec, fileName, *outDir, *noCert, opts)
+		if err != nil {
+			cli.Fatal(err)
+			return cli.ExitError
+		}
+		written = append(written, files...)
+	}
+	if g.Structured() {
+		g.Write(os.Stdout, map[string][]string{"files": written})
+		return cli.ExitOK
+	}
+	for _, file := range written {
+		fmt.Println(file)
+	}
+	return cli.ExitOK
+}
@@ -0,0 +1,98 @@
+// Package load is the load subcommand, formerly load-jwt. It calls an API over and over with a
+// newly minted JWT each time
+package load
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+func parseJSONFromFile(claimsFile string) (map[string]interface{}, error) {
+	jsonByteValue, err := os.ReadFile(claimsFile)
+	if err != nil {
+		return nil, err
+	}
+	var jsonClaims map[string]interface{}
+	if err := json.Unmarshal(jsonByteValue, &jsonClaims); err != nil {
+		return nil, err
+	}
+	return jsonClaims, nil
+}
+
+func createJwt(signer jwttools.Signer, json map[string]interface{}) (string, error) {
+	// a unique sub for every request unless the claims file sets one
+	claims := jwttools.Claims{"sub": strconv.FormatInt(time.Now().UnixNano(), 10)}
+	for jsonKey, jsonValue := range json {
+		claims[jsonKey] = jsonValue
+	}
+	return jwttools.Mint(context.Background(), claims, signer, jwttools.MintOptions{})
+}
+
+// Main runs the subcommand and returns the exit code
+func Main(g *cli.Globals, args []string) int {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	g.Register(fs)
+	claims := fs.String("claims", "", "A file of claims in json format")
+	url := fs.String("url", "", "The URL to call")
+	count := fs.Int("count", 25000, "Number of requests to run")
+	cli.Usage(fs, "Usage: jwt-tools load --key key.pem [--cert cert.pem] --claims claims.json --url <url> [--count n]")
+	if err := fs.Parse(args); err != nil {
+		return cli.FlagExit(err)
+	}
+
+	// Check that required parameters are provided
+	// --cert is only needed for the kid if the key file doesn't have one
+	if len(g.Keys) == 0 || *claims == "" || *url == "" {
+		cli.Fatal("Must provide --key, --claims and --url and usually --cert")
+		return cli.ExitError
+	}
+	json, err := parseJSONFromFile(*claims)
+	if err != nil {
+		cli.Fatal("Failed to parse claims file "+*claims+": ", err)
+		return cli.ExitError
+	}
+
+	// load the key once rather than for every JWT
+	signingKey, err := g.SigningKey()
+	if err != nil {
+		cli.Fatal("Failed to load key: ", err)
+		return cli.ExitError
+	}
+	signer := jwttools.NewSigner(signingKey)
+
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", *url, nil)
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+
+	for i := 1; i <= *count; i++ {
+		jwt, err := createJwt(signer, json)
+		if err != nil {
+			cli.Fatal("Failed to create JWS message: ", err)
+			return cli.ExitError
+		}
+		g.Logf("%s", jwt)
+		req.Header.Set("Authorization", jwt)
+		res, err := client.Do(req)
+		if err != nil {
+			cli.Fatal(err)
+			return cli.ExitError
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		fmt.Print(strconv.Itoa(i) + " " + string(body))
+	}
+	return cli.ExitOK
+}
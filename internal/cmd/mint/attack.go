@@ -0,0 +1,65 @@
+package mint
+
+/* -attack makes deliberately broken tokens to check that they're rejected */
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// listAttacks prints the names of the attacks and what they do
+func listAttacks(g *cli.Globals) int {
+	if g.Structured() {
+		list := []map[string]string{}
+		for _, attack := range jwttools.Attacks {
+			list = append(list, map[string]string{"attack": attack.Name, "description": attack.Description})
+		}
+		g.Write(os.Stdout, list)
+		return cli.ExitOK
+	}
+	for _, attack := range jwttools.Attacks {
+		fmt.Printf("%-14s %s\n", attack.Name, attack.Description)
+	}
+	return cli.ExitOK
+}
+
+// runAttack prints the named broken token, or with all every one of them with its name
+func runAttack(ctx context.Context, g *cli.Globals, name string, claims jwttools.Claims, s jwttools.Signer, opts jwttools.MintOptions) int {
+	names := []string{name}
+	if name == "all" {
+		names = nil
+		for _, attack := range jwttools.Attacks {
+			names = append(names, attack.Name)
+		}
+	}
+	var tokens []map[string]string
+	for _, attack := range names {
+		token, err := jwttools.MintAttack(ctx, attack, claims, s, opts)
+		if err != nil {
+			// with all, an attack that doesn't apply to the key, such as hs-confusion with an
+			// HMAC secret, is skipped
+			if name == "all" {
+				cli.Warning("Skipping "+attack+": ", err)
+				continue
+			}
+			cli.Fatal("Failed to create "+attack+" token: ", err)
+			return cli.ExitError
+		}
+		tokens = append(tokens, map[string]string{"attack": attack, "token": token})
+	}
+	for _, t := range tokens {
+		switch {
+		case g.Structured():
+			g.Write(os.Stdout, t)
+		case name == "all":
+			fmt.Printf("%s\t%s\n", t["attack"], t["token"])
+		default:
+			fmt.Println(t["token"])
+		}
+	}
+	return cli.ExitOK
+}
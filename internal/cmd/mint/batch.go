@@ -0,0 +1,205 @@
+package mint
+
+/* -n and -jsonl mint many tokens in one go, one per line, signing them in parallel. The tokens
+   are printed in the same order as the input, however many signers there are
+
+   String claims can have {{n}}, the number of the token counting from 1, {{uuid}} and {{rand}} in
+   them, which are replaced for each token
+*/
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// maxLine is the longest JSONL line that can be read
+const maxLine = 16 * 1024 * 1024
+
+// batchJob is one token to mint. The result goes back on its own channel so that the tokens can
+// be printed in order
+type batchJob struct {
+	n      int
+	claims jwttools.Claims
+	result chan batchResult
+}
+
+type batchResult struct {
+	token string
+	err   error
+}
+
+// expandTemplates copies the claims, replacing the templates in the strings for token n
+func expandTemplates(value interface{}, n int) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		v = strings.ReplaceAll(v, "{{n}}", strconv.Itoa(n))
+		for strings.Contains(v, "{{uuid}}") {
+			v = strings.Replace(v, "{{uuid}}", uuid.New().String(), 1)
+		}
+		for strings.Contains(v, "{{rand}}") {
+			random := make([]byte, 8)
+			if _, err := rand.Read(random); err != nil {
+				return nil, fmt.Errorf("{{rand}}: %w", err)
+			}
+			v = strings.Replace(v, "{{rand}}", hex.EncodeToString(random), 1)
+		}
+		return v, nil
+	case jwttools.Claims:
+		copied, err := expandTemplates(map[string]interface{}(v), n)
+		if err != nil {
+			return nil, err
+		}
+		return jwttools.Claims(copied.(map[string]interface{})), nil
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			expanded, err := expandTemplates(member, n)
+			if err != nil {
+				return nil, err
+			}
+			copied[name] = expanded
+		}
+		return copied, nil
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, member := range v {
+			expanded, err := expandTemplates(member, n)
+			if err != nil {
+				return nil, err
+			}
+			copied[i] = expanded
+		}
+		return copied, nil
+	}
+	return value, nil
+}
+
+// countSource gives the same claims count times
+func countSource(claims jwttools.Claims, count int) func(n int) (jwttools.Claims, bool, error) {
+	return func(n int) (jwttools.Claims, bool, error) {
+		if n > count {
+			return nil, false, nil
+		}
+		return claims, true, nil
+	}
+}
+
+// jsonlSource gives the claims on each line of the input layered on top of the other claims,
+// with the templates left for runBatch to expand. Blank lines are skipped
+func jsonlSource(base jwttools.Claims, input io.Reader) func(n int) (jwttools.Claims, bool, error) {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	line := 0
+	return func(n int) (jwttools.Claims, bool, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var layer jwttools.Claims
+			if err := decodeJSON([]byte(text), &layer); err != nil {
+				return nil, false, fmt.Errorf("line %d: %v", line, err)
+			}
+			// the base is copied so that merging the line doesn't change it for the next one
+			claims := base.Copy()
+			claims.Merge(layer)
+			return claims, true, nil
+		}
+		return nil, false, scanner.Err()
+	}
+}
+
+// runBatch mints a token for each set of claims from next with parallel signers, printing them
+// in order. It stops at the first error
+func runBatch(ctx context.Context, g *cli.Globals, next func(n int) (jwttools.Claims, bool, error), mint func(context.Context, jwttools.Claims) (string, error), parallel int) int {
+	if parallel < 1 {
+		parallel = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan *batchJob, parallel)
+	// ordered has the jobs in the order they were read so the output is in order. The buffer
+	// lets the signers get ahead of the writer
+	ordered := make(chan *batchJob, parallel*4)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(jobs)
+		defer close(ordered)
+		for n := 1; ; n++ {
+			claims, ok, err := next(n)
+			if err != nil || !ok {
+				readErr <- err
+				return
+			}
+			expanded, err := expandTemplates(claims, n)
+			if err != nil {
+				readErr <- fmt.Errorf("token %d: %w", n, err)
+				return
+			}
+			job := &batchJob{n: n, claims: expanded.(jwttools.Claims), result: make(chan batchResult, 1)}
+			select {
+			case ordered <- job:
+			case <-ctx.Done():
+				readErr <- nil
+				return
+			}
+			jobs <- job
+		}
+	}()
+	for i := 0; i < parallel; i++ {
+		go func() {
+			for job := range jobs {
+				token, err := mint(ctx, job.claims)
+				job.result <- batchResult{token, err}
+			}
+		}()
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	count := 0
+	for job := range ordered {
+		result := <-job.result
+		if result.err != nil {
+			out.Flush()
+			cli.Fatal(fmt.Sprintf("Failed to create token %d: ", job.n), result.err)
+			return cli.ExitError
+		}
+		switch {
+		case g.JSON():
+			line, _ := json.Marshal(map[string]string{"token": result.token})
+			out.Write(line)
+			out.WriteByte('\n')
+		case g.Structured():
+			g.Write(out, map[string]string{"token": result.token})
+		default:
+			out.WriteString(result.token + "\n")
+		}
+		count++
+	}
+	if err := <-readErr; err != nil {
+		out.Flush()
+		cli.Fatal("Failed to read the claims: ", err)
+		return cli.ExitError
+	}
+	g.Logf("Minted %d tokens", count)
+	return cli.ExitOK
+}
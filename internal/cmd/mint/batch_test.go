@@ -0,0 +1,130 @@
+package mint
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	claims := jwttools.Claims{
+		"sub":   "user-{{n}}",
+		"jti":   "{{uuid}} {{uuid}}",
+		"nonce": "{{rand}}",
+		"ctx":   map[string]interface{}{"id": "{{n}}", "n": json.Number("1")},
+		"roles": []interface{}{"r{{n}}", true},
+	}
+	expanded, err := expandTemplates(claims, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := expanded.(jwttools.Claims)
+	if got["sub"] != "user-7" {
+		t.Errorf("sub %v", got["sub"])
+	}
+	if uuids := strings.Fields(got["jti"].(string)); len(uuids) != 2 || uuids[0] == uuids[1] || len(uuids[0]) != 36 {
+		t.Errorf("jti %v, want two different uuids", got["jti"])
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(got["nonce"].(string)) {
+		t.Errorf("nonce %v", got["nonce"])
+	}
+	if !reflect.DeepEqual(got["ctx"], map[string]interface{}{"id": "7", "n": json.Number("1")}) {
+		t.Errorf("ctx %v", got["ctx"])
+	}
+	if !reflect.DeepEqual(got["roles"], []interface{}{"r7", true}) {
+		t.Errorf("roles %v", got["roles"])
+	}
+	if claims["sub"] != "user-{{n}}" || claims["ctx"].(map[string]interface{})["id"] != "{{n}}" {
+		t.Errorf("the claims were changed: %v", claims)
+	}
+}
+
+func TestCountSource(t *testing.T) {
+	next := countSource(jwttools.Claims{"sub": "a"}, 2)
+	for n := 1; n <= 2; n++ {
+		if claims, ok, err := next(n); !ok || err != nil || claims["sub"] != "a" {
+			t.Errorf("token %d: %v %v %v", n, claims, ok, err)
+		}
+	}
+	if _, ok, _ := next(3); ok {
+		t.Errorf("more than 2 tokens")
+	}
+}
+
+func TestJSONLSource(t *testing.T) {
+	base := jwttools.Claims{"iss": "me", "ctx": map[string]interface{}{"tenant": "a"}}
+	next := jsonlSource(base, strings.NewReader("{\"sub\":\"a\",\"ctx\":{\"user\":\"x\"}}\n\n  \n{\"sub\":\"b\"}\n"))
+	var subs []interface{}
+	for n := 1; ; n++ {
+		claims, ok, err := next(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		if claims["iss"] != "me" {
+			t.Errorf("token %d has no iss from the base: %v", n, claims)
+		}
+		subs = append(subs, claims["sub"])
+	}
+	if !reflect.DeepEqual(subs, []interface{}{"a", "b"}) {
+		t.Errorf("got subs %v", subs)
+	}
+	if !reflect.DeepEqual(base["ctx"], map[string]interface{}{"tenant": "a"}) {
+		t.Errorf("a line changed the base: %v", base)
+	}
+
+	next = jsonlSource(base, strings.NewReader("{\"sub\":\"a\"}\n\nnot json\n"))
+	next(1)
+	if _, _, err := next(2); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("got %v, want an error for line 3", err)
+	}
+}
+
+// The tokens come out in the order of the input however many signers there are
+func TestRunBatch(t *testing.T) {
+	out, err := os.CreateTemp(t.TempDir(), "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = out
+	signer := jwttools.NewHMACSigner([]byte("a secret that is long enough for HS256"))
+	mint := func(ctx context.Context, claims jwttools.Claims) (string, error) {
+		return jwttools.Mint(ctx, claims, signer, jwttools.MintOptions{})
+	}
+	code := runBatch(context.Background(), cli.NewGlobals(), countSource(jwttools.Claims{"sub": "{{n}}"}, 50), mint, 8)
+	os.Stdout = stdout
+	if code != cli.ExitOK {
+		t.Fatalf("exit %d", code)
+	}
+
+	if _, err := out.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(out)
+	n := 0
+	for scanner.Scan() {
+		n++
+		result, err := jwttools.Decode(scanner.Text())
+		if err != nil {
+			t.Fatalf("token %d: %v", n, err)
+		}
+		if result.Claims["sub"] != strconv.Itoa(n) {
+			t.Errorf("token %d has sub %v", n, result.Claims["sub"])
+		}
+	}
+	if n != 50 {
+		t.Errorf("got %d tokens, want 50", n)
+	}
+}
@@ -0,0 +1,154 @@
+package mint
+
+/* The claims of the token come from any number of claims files, layered in the order they're
+   given, and then the -c key=value options on top of them. The -header options use the same
+   key=value syntax
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// assignments are the -c and -header options
+type assignments []string
+
+func (a *assignments) String() string {
+	return strings.Join(*a, " ")
+}
+
+func (a *assignments) Set(value string) error {
+	if _, _, err := parseAssignment(value); err != nil {
+		return err
+	}
+	*a = append(*a, value)
+	return nil
+}
+
+// cutAssignment splits the option at the first = that isn't in a quoted name
+func cutAssignment(arg string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return arg[:i], arg[i+1:], true
+		}
+	}
+	return arg, "", false
+}
+
+// decodeJSON reads a JSON value, keeping numbers as json.Number so that large integers such as
+// IDs don't lose precision by going through a float64
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// parseAssignment splits a -c or -header option into the name and value. key=value gives a string
+// and key:=value parses the value as JSON, so n:=42 is a number and scopes:=["a","b"] an array. A
+// name with dots or = in it is quoted, '"https://example.com/roles":=["admin"]'
+func parseAssignment(arg string) (string, interface{}, error) {
+	path, value, found := cutAssignment(arg)
+	if !found || path == "" || path == ":" {
+		return "", nil, fmt.Errorf("invalid %q, expecting key=value or key:=json", arg)
+	}
+	if !strings.HasSuffix(path, ":") {
+		return path, value, nil
+	}
+	path = strings.TrimSuffix(path, ":")
+	var typed interface{}
+	if err := decodeJSON([]byte(value), &typed); err != nil {
+		return "", nil, fmt.Errorf("invalid JSON value in %q: %v", arg, err)
+	}
+	return path, typed, nil
+}
+
+// readClaimsFile reads a file of claims in json format, - is stdin
+func readClaimsFile(claimsFile string, stdin io.Reader) (jwttools.Claims, error) {
+	var data []byte
+	var err error
+	if claimsFile == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(claimsFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var claims jwttools.Claims
+	if err := decodeJSON(data, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// buildClaims layers the claims files in order and then sets the -c claims
+func buildClaims(files []string, args assignments, stdin io.Reader) (jwttools.Claims, error) {
+	claims := jwttools.Claims{}
+	readStdin := false
+	for _, file := range files {
+		if file == "-" {
+			if readStdin {
+				return nil, fmt.Errorf("--claims - can only be given once")
+			}
+			readStdin = true
+		}
+		layer, err := readClaimsFile(file, stdin)
+		if err != nil {
+			return nil, fmt.Errorf("claims file %s: %v", file, err)
+		}
+		claims.Merge(layer)
+	}
+	for _, arg := range args {
+		path, value, err := parseAssignment(arg)
+		if err != nil {
+			return nil, err
+		}
+		if err := claims.SetPath(path, value); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// buildHeaders turns the header options into the extra headers. The -header options are applied
+// last so they can override anything
+func buildHeaders(named map[string]string, args assignments) (map[string]interface{}, error) {
+	headers := map[string]interface{}{}
+	for name, value := range named {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	for _, arg := range args {
+		name, value, err := parseAssignment(arg)
+		if err != nil {
+			return nil, err
+		}
+		// a header is a name, not a path, the quotes only let it have = in it
+		if len(name) > 1 && (name[0] == '"' || name[0] == '\'') && name[len(name)-1] == name[0] {
+			name = name[1 : len(name)-1]
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
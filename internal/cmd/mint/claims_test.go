@@ -0,0 +1,80 @@
+package mint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+func TestParseAssignment(t *testing.T) {
+	tests := []struct {
+		arg   string
+		path  string
+		value interface{}
+	}{
+		{"sub=alice", "sub", "alice"},
+		{"sub=a=b", "sub", "a=b"},
+		{"n:=42", "n", json.Number("42")},
+		{"id:=12345678901234567890", "id", json.Number("12345678901234567890")},
+		{"admin:=true", "admin", true},
+		{`scopes:=["a","b"]`, "scopes", []interface{}{"a", "b"}},
+		{"ctx.tenant=acme", "ctx.tenant", "acme"},
+		{`"a=b"=c`, `"a=b"`, "c"},
+		{"empty=", "empty", ""},
+	}
+	for _, test := range tests {
+		path, value, err := parseAssignment(test.arg)
+		if err != nil || path != test.path || !reflect.DeepEqual(value, test.value) {
+			t.Errorf("parseAssignment(%q) = %q, %#v, %v, want %q, %#v", test.arg, path, value, err, test.path, test.value)
+		}
+	}
+	for _, arg := range []string{"sub", "n:=", "n:=forty", "n:=1 2", `"a=b`} {
+		if _, _, err := parseAssignment(arg); err == nil {
+			t.Errorf("parseAssignment(%q) didn't fail", arg)
+		}
+	}
+}
+
+func TestBuildClaims(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.json")
+	if err := os.WriteFile(base, []byte(`{"sub":"alice","ctx":{"tenant":"a","region":"eu"},"id":12345678901234567890}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdin := strings.NewReader(`{"ctx":{"tenant":"b"}}`)
+	claims, err := buildClaims([]string{base, "-"}, assignments{"scope=read", "ctx.user=bob"}, stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := jwttools.Claims{
+		"sub":   "alice",
+		"ctx":   map[string]interface{}{"tenant": "b", "region": "eu", "user": "bob"},
+		"id":    json.Number("12345678901234567890"),
+		"scope": "read",
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Errorf("got %v, want %v", claims, want)
+	}
+	if _, err := buildClaims([]string{"-", "-"}, nil, strings.NewReader("{}")); err == nil {
+		t.Errorf("read stdin twice")
+	}
+	if _, err := buildClaims([]string{filepath.Join(dir, "missing.json")}, nil, nil); err == nil {
+		t.Errorf("read a missing file")
+	}
+}
+
+func TestBuildHeaders(t *testing.T) {
+	headers, err := buildHeaders(map[string]string{"kid": "k1", "typ": ""}, assignments{"typ=at+jwt", `"a=b":=1`, "kid=k2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"kid": "k2", "typ": "at+jwt", "a=b": json.Number("1")}
+	if !reflect.DeepEqual(headers, want) {
+		t.Errorf("got %v, want %v", headers, want)
+	}
+}
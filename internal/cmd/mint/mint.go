@@ -0,0 +1,393 @@
+// Package mint is the mint subcommand, formerly mk-jwt. It signs a JWT with the claims from files
+// and the command line and checks it verifies before printing it
+package mint
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// options are the mint flags, the key flags are globals
+type options struct {
+	claims      cli.Files
+	claimArgs   assignments
+	kid         string
+	typ         string
+	cty         string
+	jku         string
+	x5c         bool
+	x5t         bool
+	x5tS256     bool
+	headers     assignments
+	policy      string
+	subject     string
+	expiry      string
+	notBefore   string
+	issuer      string
+	audience    cli.Files
+	jti         string
+	noDefault   bool
+	iatOffset   int
+	randomSub   bool
+	useHMAC     bool
+	shortSecret bool
+	genSecret   bool
+	attack      string
+	count       int
+	jsonl       string
+	parallel    int
+	format      string
+	encrypt     string
+	jweAlg      string
+	jweEnc      string
+}
+
+// mintOptions turns the command line options into the jwttools ones
+func (o *options) mintOptions() (jwttools.MintOptions, error) {
+	opts := jwttools.MintOptions{
+		IssuedAtOffset: time.Duration(o.iatOffset) * time.Second,
+		Issuer:         o.issuer,
+		Audience:       o.audience,
+		JTI:            o.jti,
+		RandomSubject:  o.randomSub,
+		Subject:        o.subject,
+		Policy:         o.policy,
+		NoDefaults:     o.noDefault,
+		X5C:            o.x5c,
+		X5T:            o.x5t,
+		X5TS256:        o.x5tS256,
+	}
+	headers, err := buildHeaders(map[string]string{"typ": o.typ, "cty": o.cty, "jku": o.jku}, o.headers)
+	if err != nil {
+		return opts, err
+	}
+	opts.Headers = headers
+	if o.expiry != "" {
+		duration, err := time.ParseDuration(o.expiry)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --exp: %v", err)
+		}
+		opts.Expiry = duration
+	}
+	if o.notBefore != "" {
+		duration, err := time.ParseDuration(o.notBefore)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --nbf: %v", err)
+		}
+		opts.NotBefore = &duration
+	}
+	return opts, nil
+}
+
+// signers are the keys from the globals, or the HMAC secret with --hmac. Only --format general
+// can sign with more than one --key. It also gives the key to verify each signature with
+func signers(g *cli.Globals, o *options) ([]jwttools.Signer, []jwttools.KeySource, error) {
+	if o.useHMAC {
+		secret, err := g.Secret()
+		if err != nil {
+			return nil, nil, err
+		}
+		if secret == nil {
+			return nil, nil, fmt.Errorf("must provide --hmac-secret, --hmac-secret-file or --hmac-secret-env when using --hmac mode")
+		}
+		hmacSigner := jwttools.NewHMACSigner(secret)
+		// RFC 7518 says the secret MUST be at least as long as the hash
+		if err := jwttools.CheckSecret(hmacSigner.Algorithm, secret); err != nil {
+			if !o.shortSecret {
+				return nil, nil, fmt.Errorf("%v, use a longer secret or --allow-short-secret", err)
+			}
+			cli.Warning(err)
+		}
+		hmacSigner.KeyID = o.kid
+		return []jwttools.Signer{hmacSigner}, []jwttools.KeySource{jwttools.HMACSecret(secret)}, nil
+	}
+	if len(g.Keys) > 1 && o.format != jwttools.FormatGeneral {
+		return nil, nil, fmt.Errorf("only --format general can sign with more than one --key")
+	}
+	if len(g.Keys) > 1 && o.kid != "" {
+		return nil, nil, fmt.Errorf("--kid can't be used with more than one --key, give each one a --cert or a kid in a JWK")
+	}
+	// the keys can be in any format, if they're JWKs or have a certificate with them then they have a kid
+	found, err := g.SigningKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	var signed []jwttools.Signer
+	var verifyWith []jwttools.KeySource
+	for i, key := range found {
+		if len(key.Certificates) > 0 && i < len(g.Certs) {
+			g.Logf("Serial number: %s", key.KeyID)
+		}
+		if o.kid != "" {
+			key.KeyID = o.kid
+		}
+		// with --cert the token is verified against the certificate rather than the key
+		verifyKey := &keys.Key{Public: key.Public, KeyID: key.KeyID}
+		if i < len(g.Certs) {
+			verifyKey.Public = key.Certificates[0].PublicKey
+		}
+		if key.KeyID == "" {
+			cli.Warning("No --cert or --kid given and " + g.Keys[i] + " has no kid, the JWT will have no kid")
+		}
+		signed = append(signed, jwttools.NewSigner(key))
+		verifyWith = append(verifyWith, jwttools.StaticKeys{verifyKey})
+	}
+	return signed, verifyWith, nil
+}
+
+// encrypter is the key from --encrypt-key, or nil when the token isn't to be encrypted. A file
+// with a symmetric (oct) JWK is used with dir, anything else has to have an RSA or EC public key
+// or certificate in it
+func encrypter(o *options) (*jwttools.Encrypter, error) {
+	if o.encrypt == "" {
+		if o.jweAlg != "" || o.jweEnc != "" {
+			return nil, fmt.Errorf("--jwe-alg and --jwe-enc need --encrypt-key")
+		}
+		return nil, nil
+	}
+	var e jwttools.Encrypter
+	if secret, kid, err := keys.LoadSecret(o.encrypt); err == nil {
+		e = jwttools.NewDirectEncrypter(secret, kid)
+	} else {
+		key, err := keys.LoadPublicKey(o.encrypt, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(key.Certificates) > 0 && key.KeyID == "" {
+			key.KeyID = key.Certificates[0].SerialNumber.String()
+		}
+		if e, err = jwttools.NewEncrypter(key); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.encrypt, err)
+		}
+	}
+	if o.jweAlg != "" {
+		e.KeyAlgorithm = o.jweAlg
+	}
+	if o.jweEnc != "" {
+		e.ContentEncryption = o.jweEnc
+	}
+	return &e, nil
+}
+
+// Main runs the subcommand and returns the exit code
+func Main(g *cli.Globals, args []string) int {
+	o := &options{}
+	fs := flag.NewFlagSet("mint", flag.ContinueOnError)
+	g.Register(fs)
+	fs.Var(&o.claims, "claims", "A file of claims in json format, - for stdin. Can be repeated, later files override earlier ones")
+	fs.Var(&o.claimArgs, "c", "Set a claim: key=string or key:=json, e.g. -c n:=42 -c 'scopes:=[\"a\"]'. Dotted keys set members of objects, e.g. -c ctx.tenant=acme, quote a name with dots in it. Can be repeated")
+	fs.StringVar(&o.policy, "policy", "", "The policy to put in the 'pol' claim")
+	fs.StringVar(&o.subject, "subject", "", "The subject to put in the 'sub' claim")
+	fs.StringVar(&o.expiry, "exp", "", "Duration for JWT expiration (e.g., '1h', '30m', '24h')")
+	fs.StringVar(&o.notBefore, "nbf", "", "Set the 'nbf' claim to now plus this duration (e.g., '0s', '-5m', '1h')")
+	fs.StringVar(&o.issuer, "iss", "", "The issuer to put in the 'iss' claim")
+	fs.Var(&o.audience, "aud", "An audience to put in the 'aud' claim, can be repeated")
+	fs.StringVar(&o.jti, "jti", "", "The 'jti' claim: auto for a random one, none for no jti, or the value to use (default auto)")
+	fs.BoolVar(&o.noDefault, "no-defaults", false, "Only put the claims given in the token, no default 'iat' or 'jti'")
+	fs.StringVar(&o.kid, "kid", "", "The 'kid' header, overriding the kid from the key or --cert")
+	fs.StringVar(&o.typ, "typ", "", "The 'typ' header, e.g. at+jwt (default JWT)")
+	fs.StringVar(&o.cty, "cty", "", "The 'cty' header")
+	fs.StringVar(&o.jku, "jku", "", "The 'jku' header, the URL of the JWKS with the key in it")
+	fs.BoolVar(&o.x5c, "x5c", false, "Put the certificate chain in the 'x5c' header")
+	fs.BoolVar(&o.x5t, "x5t", false, "Put the SHA-1 thumbprint of the certificate in the 'x5t' header")
+	fs.BoolVar(&o.x5tS256, "x5t#S256", false, "Put the SHA-256 thumbprint of the certificate in the 'x5t#S256' header")
+	fs.Var(&o.headers, "header", "Set a header: key=string or key:=json, overriding any other header. Can be repeated")
+	fs.IntVar(&o.iatOffset, "iat-offset", 0, "Offset for IssuedAt time in seconds (can be positive or negative)")
+	fs.BoolVar(&o.randomSub, "random", false, "Set a random 'sub' claim")
+	fs.StringVar(&o.attack, "attack", "", "Make a deliberately broken token instead: the name of the attack, all for every one or list to list them")
+	fs.IntVar(&o.count, "n", 0, "Mint this many tokens, one per line. String claims can use {{n}}, {{uuid}} and {{rand}}")
+	fs.StringVar(&o.jsonl, "jsonl", "", "Mint a token for each line of this file of JSON claims, - for stdin. The other claims are used under each line")
+	fs.IntVar(&o.parallel, "parallel", runtime.NumCPU(), "How many tokens to sign at once with --n or --jsonl")
+	fs.BoolVar(&o.useHMAC, "hmac", false, "Use HMAC signing with --hmac-secret, --hmac-secret-file or --hmac-secret-env instead of --key")
+	fs.BoolVar(&o.shortSecret, "allow-short-secret", false, "Sign with an HMAC secret shorter than the hash, 32 bytes for HS256, which RFC 7518 forbids")
+	fs.BoolVar(&o.genSecret, "generate-secret", false, "Print a random HMAC secret as an oct JWK, for --hmac-secret-file, and exit")
+	fs.StringVar(&o.format, "format", jwttools.FormatCompact, "The JWS serialization: compact, or flattened or general JSON. General can be signed by several --key at once, each with its own protected header and kid")
+	fs.StringVar(&o.encrypt, "encrypt-key", "", "Encrypt the token (JWE) to this RSA or EC public key or certificate, or with this oct JWK using dir. The signed token is nested inside with cty JWT, without --key or --hmac the claims are encrypted unsigned")
+	fs.StringVar(&o.jweAlg, "jwe-alg", "", "The JWE key management alg: "+strings.Join(jwttools.KeyAlgorithms, ", ")+" (default RSA-OAEP-256 for RSA, ECDH-ES for EC)")
+	fs.StringVar(&o.jweEnc, "jwe-enc", "", "The JWE content encryption: A128GCM, A256GCM or A128CBC-HS256 (default A256GCM, A128GCM for a 16 byte dir key)")
+	cli.Usage(fs, "Usage: jwt-tools mint --key key.pem [--cert cert.pem] [--claims claims.json ...] [-c key=value ...] [options]",
+		"       jwt-tools mint --hmac --hmac-secret-file secret.json [--claims claims.json ...] [-c key=value ...] [options]",
+		"       jwt-tools mint --generate-secret > secret.json",
+		"       jwt-tools mint [--key key.pem] --encrypt-key recipient.pem [--jwe-alg alg] [--jwe-enc enc] [options]")
+	if err := fs.Parse(args); err != nil {
+		return cli.FlagExit(err)
+	}
+	if err := g.Check(); err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+	if o.attack == "list" {
+		return listAttacks(g)
+	}
+	if o.genSecret {
+		return generateSecret()
+	}
+	switch o.format {
+	case jwttools.FormatCompact, jwttools.FormatFlattened, jwttools.FormatGeneral:
+	default:
+		cli.Fatal(fmt.Sprintf("unknown --format %q, must be compact, flattened or general", o.format))
+		return cli.ExitError
+	}
+	if o.format != jwttools.FormatCompact && (o.attack != "" || o.encrypt != "") {
+		cli.Fatal("--attack and --encrypt-key only make compact tokens")
+		return cli.ExitError
+	}
+	batch := o.count > 0 || o.jsonl != ""
+	if o.count > 0 && o.jsonl != "" {
+		cli.Fatal("--n and --jsonl can't be used together")
+		return cli.ExitError
+	}
+	if batch && o.attack != "" {
+		cli.Fatal("--attack can't be used with --n or --jsonl")
+		return cli.ExitError
+	}
+	if o.jsonl == "-" {
+		for _, file := range o.claims {
+			if file == "-" {
+				cli.Fatal("--jsonl - and --claims - can't both read stdin")
+				return cli.ExitError
+			}
+		}
+	}
+
+	claims, err := buildClaims(o.claims, o.claimArgs, os.Stdin)
+	if err != nil {
+		cli.Fatal("Failed to build the claims: ", err)
+		return cli.ExitError
+	}
+	opts, err := o.mintOptions()
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+	e, err := encrypter(o)
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitError
+	}
+	if e != nil && o.attack != "" {
+		cli.Fatal("--attack can't be used with --encrypt-key")
+		return cli.ExitError
+	}
+	if e != nil {
+		g.Logf("Encrypting with %s and %s, key ID: %s", e.KeyAlgorithm, e.ContentEncryption, e.KeyID)
+	}
+	// with only --encrypt-key the claims are encrypted without being signed
+	signed := e == nil || len(g.Keys) > 0 || o.useHMAC
+	var all []jwttools.Signer
+	var verifyWith []jwttools.KeySource
+	if signed {
+		if all, verifyWith, err = signers(g, o); err != nil {
+			cli.Fatal(err)
+			return cli.ExitError
+		}
+		for _, s := range all {
+			g.Logf("Key ID: %s, algorithm: %s", s.KeyID, s.Algorithm)
+		}
+	}
+	mint := func(ctx context.Context, claims jwttools.Claims) (string, error) {
+		switch {
+		case !signed:
+			return jwttools.MintEncrypted(ctx, claims, *e, opts)
+		case o.format != jwttools.FormatCompact:
+			return jwttools.MintJSON(ctx, claims, all, opts, o.format)
+		}
+		token, err := jwttools.Mint(ctx, claims, all[0], opts)
+		if err != nil || e == nil {
+			return token, err
+		}
+		return jwttools.Nest(token, *e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if o.attack != "" {
+		return runAttack(ctx, g, o.attack, claims, all[0], opts)
+	}
+	switch {
+	case o.count > 0:
+		return runBatch(context.Background(), g, countSource(claims, o.count), mint, o.parallel)
+	case o.jsonl == "-":
+		return runBatch(context.Background(), g, jsonlSource(claims, os.Stdin), mint, o.parallel)
+	case o.jsonl != "":
+		input, err := os.Open(o.jsonl)
+		if err != nil {
+			cli.Fatal(err)
+			return cli.ExitError
+		}
+		defer input.Close()
+		return runBatch(context.Background(), g, jsonlSource(claims, input), mint, o.parallel)
+	}
+	if !signed {
+		token, err := jwttools.MintEncrypted(ctx, claims, *e, opts)
+		if err != nil {
+			cli.Fatal("Failed to create JWE message: ", err)
+			return cli.ExitError
+		}
+		return printToken(g, token)
+	}
+	var token string
+	if o.format == jwttools.FormatCompact {
+		token, err = jwttools.Mint(ctx, claims, all[0], opts)
+	} else {
+		token, err = jwttools.MintJSON(ctx, claims, all, opts, o.format)
+	}
+	if err != nil {
+		cli.Fatal("Failed to create JWS message: ", err)
+		return cli.ExitError
+	}
+
+	// only the signatures are checked, a token minted with --iat-offset may not be valid yet. Each
+	// key on its own has to verify the token, so every signature is checked
+	var result *jwttools.Result
+	for i, source := range verifyWith {
+		if result, err = jwttools.Verify(ctx, token, source, jwttools.Policy{SkipValidation: true}); err != nil {
+			cli.Fatal("Failed to verify the new token: ", err)
+			return cli.ExitError
+		}
+		g.Logf("Signed message verified with kid %q and %s", all[i].KeyID, all[i].Algorithm)
+	}
+	if g.Verbose {
+		g.Logf("All claims:")
+		for _, name := range cli.SortedKeys(result.Claims) {
+			g.Logf("%s -> %v", name, result.Claims[name])
+		}
+	}
+	if e != nil {
+		if token, err = jwttools.Nest(token, *e); err != nil {
+			cli.Fatal("Failed to create JWE message: ", err)
+			return cli.ExitError
+		}
+	}
+	return printToken(g, token)
+}
+
+// generateSecret prints a random secret for HS256 as an oct JWK. It's JSON whatever --output says
+func generateSecret() int {
+	key, err := jwttools.GenerateSecret(jwttools.NewHMACSigner(nil).Algorithm)
+	if err != nil {
+		cli.Fatal("Failed to generate a secret: ", err)
+		return cli.ExitError
+	}
+	cli.WriteJSON(os.Stdout, key)
+	return cli.ExitOK
+}
+
+// printToken prints the token on its own or in JSON with --output json
+func printToken(g *cli.Globals, token string) int {
+	if g.Structured() {
+		g.Write(os.Stdout, map[string]string{"token": token})
+	} else {
+		fmt.Println(token)
+	}
+	return cli.ExitOK
+}
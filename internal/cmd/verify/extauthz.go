@@ -0,0 +1,100 @@
+package verify
+
+/* verify serve --grpc-addr also answers Envoy's ext_authz filter, the
+   envoy.service.auth.v3.Authorization gRPC service, with the same decision as the HTTP endpoint.
+   A valid token is OK with the claims as headers for Envoy to add to the request, anything else is
+   DENIED with the status, WWW-Authenticate and report the HTTP endpoint would answer with
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// sortedNames are the names of the headers, sorted
+func sortedNames[T any](headers map[string]T) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// extAuthz is the ext_authz service, answering with the server's decisions
+type extAuthz struct {
+	*server
+}
+
+// grpcCodes are the gRPC status of a decision that isn't OK
+var grpcCodes = map[int]codes.Code{
+	http.StatusUnauthorized:       codes.Unauthenticated,
+	http.StatusForbidden:          codes.PermissionDenied,
+	http.StatusServiceUnavailable: codes.Unavailable,
+}
+
+// headerOptions are the headers for Envoy, which replace any the request already has
+func headerOptions(headers http.Header) []*corev3.HeaderValueOption {
+	var options []*corev3.HeaderValueOption
+	for _, name := range sortedNames(headers) {
+		options = append(options, &corev3.HeaderValueOption{Header: &corev3.HeaderValue{Key: strings.ToLower(name), Value: headers.Get(name)}})
+	}
+	return options
+}
+
+// spoofed are the request's headers that look like they came from a valid token but didn't, those
+// with the prefix or a --claim-header name that the decision doesn't set, so the upstream can trust
+// them. Envoy gives the header names in lower case
+func (s *server) spoofed(request map[string]string, set http.Header) []string {
+	var remove []string
+	for _, name := range sortedNames(request) {
+		ours := s.prefix != "" && strings.HasPrefix(name, strings.ToLower(s.prefix))
+		for _, m := range s.mapping {
+			ours = ours || name == strings.ToLower(m.header)
+		}
+		if ours && set.Get(name) == "" {
+			remove = append(remove, name)
+		}
+	}
+	return remove
+}
+
+func (e extAuthz) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	start := time.Now()
+	httpRequest := req.GetAttributes().GetRequest().GetHttp()
+	d := e.decide(ctx, httpRequest.GetHeaders()["authorization"])
+	var response *authv3.CheckResponse
+	if d.status == http.StatusOK {
+		response = &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+				Headers:         headerOptions(d.headers),
+				HeadersToRemove: e.spoofed(httpRequest.GetHeaders(), d.headers),
+			}},
+		}
+	} else {
+		d.headers.Set("Content-Type", "application/json")
+		body, _ := json.MarshalIndent(d.report, "", "  ")
+		response = &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(grpcCodes[d.status]), Message: d.code},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode(d.status)},
+				Headers: headerOptions(d.headers),
+				Body:    string(body) + "\n",
+			}},
+		}
+	}
+	e.metrics.request("grpc", d.status, d.code, time.Since(start))
+	e.g.Logf("ext_authz %s %s%s: %d %s", httpRequest.GetMethod(), httpRequest.GetHost(), httpRequest.GetPath(), d.status, d.code)
+	return response, nil
+}
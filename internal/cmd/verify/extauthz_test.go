@@ -0,0 +1,146 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+	"google.golang.org/grpc/codes"
+)
+
+// testExtAuthz is the ext_authz service with the test secret as the key and a rule for the scope
+func testExtAuthz(t *testing.T) extAuthz {
+	t.Helper()
+	g := cli.NewGlobals()
+	g.HMACSecret = testSecret
+	o := options{policyExprs: cli.Files{"scope contains orders:write"}}
+	policy, err := o.policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := o.load(g, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMetrics()
+	v.ttl, v.metrics, v.routes = time.Minute, m, map[string]route{}
+	v.source = v.cache(v.source)
+	return extAuthz{&server{g: g, verifier: v, prefix: "X-Jwt-", mapping: []claimHeader{{"realm.role", "X-Role"}}, metrics: m}}
+}
+
+// check asks the service about a request with the headers, Envoy's names being lower case
+func check(t *testing.T, e extAuthz, headers map[string]string) *authv3.CheckResponse {
+	t.Helper()
+	resp, err := e.Check(context.Background(), &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Method: http.MethodGet, Host: "api.example.com", Path: "/orders", Headers: headers,
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestExtAuthzCheck(t *testing.T) {
+	e := testExtAuthz(t)
+	valid := jwttools.Claims{"sub": "alice", "scope": "orders:write", "realm": map[string]interface{}{"role": "ops"}}
+	tests := []struct {
+		name          string
+		authorization string
+		grpc          codes.Code
+		status        int
+		code          string
+	}{
+		{"valid", "Bearer " + testToken(t, valid, testSecret), codes.OK, http.StatusOK, ""},
+		{"no token", "", codes.Unauthenticated, http.StatusUnauthorized, "missing_token"},
+		{"wrong key", "Bearer " + testToken(t, valid, "another secret that is long enough too"), codes.Unauthenticated, http.StatusUnauthorized, "bad_signature"},
+		{"policy", "Bearer " + testToken(t, jwttools.Claims{"sub": "bob", "scope": "orders:read"}, testSecret), codes.PermissionDenied, http.StatusForbidden, "policy_failed"},
+	}
+	for _, test := range tests {
+		headers := map[string]string{}
+		if test.authorization != "" {
+			headers["authorization"] = test.authorization
+		}
+		resp := check(t, e, headers)
+		if got := codes.Code(resp.GetStatus().GetCode()); got != test.grpc {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.grpc)
+			continue
+		}
+		if test.grpc == codes.OK {
+			if resp.GetOkResponse() == nil {
+				t.Errorf("%s: no OK response", test.name)
+			}
+			continue
+		}
+		denied := resp.GetDeniedResponse()
+		if denied == nil || int(denied.GetStatus().GetCode()) != test.status {
+			t.Errorf("%s: got the denied response %v, want %d", test.name, denied, test.status)
+			continue
+		}
+		if resp.GetStatus().GetMessage() != test.code {
+			t.Errorf("%s: got the message %q, want %q", test.name, resp.GetStatus().GetMessage(), test.code)
+		}
+		var r report
+		if err := json.Unmarshal([]byte(denied.GetBody()), &r); err != nil || len(r.Errors) != 1 || r.Errors[0].Code != test.code {
+			t.Errorf("%s: got the body %s, %v, want the code %s", test.name, denied.GetBody(), err, test.code)
+		}
+		var challenge bool
+		for _, header := range denied.GetHeaders() {
+			challenge = challenge || header.GetHeader().GetKey() == "www-authenticate"
+		}
+		if !challenge {
+			t.Errorf("%s: no WWW-Authenticate in %v", test.name, denied.GetHeaders())
+		}
+	}
+}
+
+// A valid token's claims are added as headers, and the request's own headers that look like a
+// token's claims but aren't are removed, so the upstream can trust them
+func TestExtAuthzHeaders(t *testing.T) {
+	e := testExtAuthz(t)
+	token := testToken(t, jwttools.Claims{"sub": "alice", "scope": "orders:write"}, testSecret)
+	resp := check(t, e, map[string]string{
+		"authorization": "Bearer " + token,
+		"x-jwt-admin":   "true",
+		"x-role":        "admin",
+		"x-jwt-sub":     "mallory",
+		"accept":        "*/*",
+	})
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("got %v", resp)
+	}
+	added := map[string]string{}
+	for _, header := range ok.GetHeaders() {
+		added[header.GetHeader().GetKey()] = header.GetHeader().GetValue()
+	}
+	if added["x-jwt-sub"] != "alice" || added["x-jwt-scope"] != "orders:write" {
+		t.Errorf("got the headers %v", added)
+	}
+	if remove := strings.Join(ok.GetHeadersToRemove(), ","); remove != "x-jwt-admin,x-role" {
+		t.Errorf("got the headers to remove %s, want x-jwt-admin,x-role", remove)
+	}
+}
+
+// ext_authz answers are counted apart from the HTTP ones
+func TestExtAuthzMetrics(t *testing.T) {
+	e := testExtAuthz(t)
+	check(t, e, map[string]string{"authorization": "Bearer " + testToken(t, jwttools.Claims{"sub": "alice", "scope": "orders:write"}, testSecret)})
+	check(t, e, map[string]string{})
+	samples := e.metrics.requests
+	for _, sample := range []string{
+		`protocol="grpc",status="200",code="ok"`,
+		`protocol="grpc",status="401",code="missing_token"`,
+	} {
+		if samples[sample] != 1 {
+			t.Errorf("%s: got %d, want 1 in %v", sample, samples[sample], samples)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration histogram
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// metrics counts what verify serve has answered, served in the Prometheus text format
+type metrics struct {
+	mu       sync.Mutex
+	requests map[string]uint64 // by the protocol, status and code labels
+	fetches  map[string]uint64 // by the result label, ok or error
+	buckets  []uint64          // requests that took no longer than each of durationBuckets
+	count    uint64
+	seconds  float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: map[string]uint64{}, fetches: map[string]uint64{}, buckets: make([]uint64, len(durationBuckets))}
+}
+
+// request counts an answer by http or grpc
+func (m *metrics) request(protocol string, status int, code string, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[fmt.Sprintf("protocol=%q,status=%q,code=%q", protocol, strconv.Itoa(status), code)]++
+	for i, bound := range durationBuckets {
+		if took.Seconds() <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.seconds += took.Seconds()
+}
+
+// fetch counts the keys being fetched, or failing to be
+func (m *metrics) fetch(err error) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetches[fmt.Sprintf("result=%q", result)]++
+}
+
+// writeCounter writes a counter with a sample for each of its labels, sorted
+func writeCounter(w http.ResponseWriter, name, help string, samples map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	labels := make([]string, 0, len(samples))
+	for label := range samples {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, label, samples[label])
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeCounter(w, "jwt_verify_requests_total", "Forward auth requests answered, by protocol, HTTP status and error code.", m.requests)
+	writeCounter(w, "jwt_verify_key_fetches_total", "Times the keys were fetched, or loaded, by result.", m.fetches)
+	name := "jwt_verify_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time taken to answer a forward auth request.\n# TYPE %s histogram\n", name, name)
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound, 'f', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, m.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(m.seconds, 'f', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, m.count)
+}
+
+// countedKeys counts each time the keys are had from the source
+type countedKeys struct {
+	source  jwttools.KeySource
+	metrics *metrics
+}
+
+func (c countedKeys) KeySet(ctx context.Context) (jwk.Set, error) {
+	set, err := c.source.KeySet(ctx)
+	c.metrics.fetch(err)
+	return set, err
+}
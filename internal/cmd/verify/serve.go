@@ -0,0 +1,329 @@
+package verify
+
+/* jwt-tools verify serve answers forward auth requests, nginx's auth_request and Traefik's
+   ForwardAuth. The bearer token in the Authorization header is verified as verify would, and the
+   answer is 200 with the claims in response headers for the proxy to pass on, or 401 or 403 with
+   why. The keys and each issuer's metadata are cached, and /metrics counts the answers. See
+   extauthz.go for Envoy
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+	"google.golang.org/grpc"
+)
+
+// claimHeader is a claim, or a dotted path into one, and the response header it's returned in
+type claimHeader struct {
+	claim  string
+	header string
+}
+
+// parseClaimHeaders splits each claim=Header
+func parseClaimHeaders(list []string) ([]claimHeader, error) {
+	var mapping []claimHeader
+	for _, item := range list {
+		claim, header, ok := strings.Cut(item, "=")
+		if !ok || claim == "" || !headerName(header) {
+			return nil, fmt.Errorf("--claim-header %q isn't claim=Header-Name", item)
+		}
+		mapping = append(mapping, claimHeader{claim, header})
+	}
+	return mapping, nil
+}
+
+// headerName reports whether the name can be used as a header without surprising a proxy: letters,
+// digits and dashes. nginx drops headers with underscores by default
+func headerName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// headerValue writes a claim for a header: strings as they are, arrays joined with commas, times
+// as seconds since the epoch and anything else as JSON
+func headerValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return strconv.FormatInt(v.Unix(), 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ",")
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, member := range v {
+			list[i] = headerValue(member)
+		}
+		return strings.Join(list, ",")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// bearer reads the token from an Authorization header
+func bearer(authorization string) (string, error) {
+	if authorization == "" {
+		return "", errors.New("no Authorization header")
+	}
+	scheme, token, _ := strings.Cut(authorization, " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("the Authorization header isn't a Bearer token")
+	}
+	return token, nil
+}
+
+// status is the HTTP status for an error code. A token that's fine but that the policy doesn't let
+// in is forbidden, 403, one that isn't good is unauthorized, 401. Keys that can't be fetched are
+// the server's problem, 503, which the proxy treats as an error rather than a denial
+func status(code, kind string) int {
+	switch {
+	case kind == jwttools.KindKeys:
+		return http.StatusServiceUnavailable
+	case code == "policy_failed" || code == "invalid_aud":
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// server answers forward auth requests, by HTTP and by Envoy's ext_authz
+type server struct {
+	g        *cli.Globals
+	verifier *verifier
+	prefix   string
+	mapping  []claimHeader
+	metrics  *metrics
+}
+
+// decision is the answer to a forward auth request, whichever way it came
+type decision struct {
+	status  int         // 200, or 401, 403 or 503 with why in the report
+	code    string      // ok, or the error code
+	headers http.Header // the claims of a valid token, WWW-Authenticate for one that isn't
+	report  report      // the report verify --output json would give for a token that isn't valid
+}
+
+// decide verifies the token in the Authorization header
+func (s *server) decide(ctx context.Context, authorization string) decision {
+	d := decision{headers: http.Header{}}
+	token, err := bearer(authorization)
+	if err != nil {
+		// RFC 6750 section 3.1, a request without a token gets no error code
+		d.status, d.code = http.StatusUnauthorized, "missing_token"
+		d.headers.Set("WWW-Authenticate", `Bearer realm="jwt"`)
+		d.report = report{Errors: []errorReport{{Code: d.code, Kind: jwttools.KindMalformed, Message: err.Error()}}}
+		return d
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	result, policy, err := s.verifier.verify(ctx, token)
+	if err != nil {
+		code, kind := jwttools.ErrorCode(err)
+		d.status, d.code = status(code, kind), code
+		switch d.status {
+		case http.StatusUnauthorized:
+			d.headers.Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="jwt", error="invalid_token", error_description=%q`, describeError(code, err)))
+		case http.StatusForbidden:
+			d.headers.Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="jwt", error="insufficient_scope", error_description=%q`, describeError(code, err)))
+		}
+		d.report = report{Errors: []errorReport{{Code: code, Kind: kind, Message: err.Error()}}}
+		var policyErr *jwttools.PolicyError
+		if errors.As(err, &policyErr) {
+			d.report.Rules = policyErr.Results
+		}
+		if decoded, err := jwttools.Decode(token, policy.DecryptionKeys...); err == nil {
+			d.report.Header = decoded.Header
+		}
+		return d
+	}
+	d.status, d.code = http.StatusOK, "ok"
+	if s.prefix != "" {
+		for _, name := range cli.SortedKeys(result.Claims) {
+			header := s.prefix + strings.NewReplacer("_", "-", ".", "-").Replace(name)
+			if headerName(header) {
+				d.headers.Set(header, headerValue(result.Claims[name]))
+			}
+		}
+	}
+	for _, m := range s.mapping {
+		if value, ok := jwttools.Lookup(result.Claims, m.claim); ok {
+			d.headers.Set(m.header, headerValue(value))
+		}
+	}
+	return d
+}
+
+// mux is every endpoint: /metrics, /healthz and forward auth on any other path, which is whatever
+// the proxy is configured with
+func (s *server) mux() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/", s)
+	return mux
+}
+
+// describeError is the error for a WWW-Authenticate error_description, which can't have quotes
+// or backslashes in it
+func describeError(code string, err error) string {
+	return strings.NewReplacer(`"`, "'", `\`, "/").Replace(code + ": " + err.Error())
+}
+
+// ServeHTTP is the forward auth endpoint, answering with no body when the token is valid and with
+// the report when it isn't
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	d := s.decide(r.Context(), r.Header.Get("Authorization"))
+	for name, values := range d.headers {
+		w.Header()[name] = values
+	}
+	if d.status == http.StatusOK {
+		w.WriteHeader(d.status)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(d.status)
+		cli.WriteJSON(w, d.report)
+	}
+	s.metrics.request("http", d.status, d.code, time.Since(start))
+	s.g.Logf("%s %s %s: %d %s", r.RemoteAddr, r.Method, r.URL.Path, d.status, d.code)
+}
+
+// serve runs verify serve and returns the exit code
+func serve(g *cli.Globals, args []string) int {
+	fs := flag.NewFlagSet("verify serve", flag.ContinueOnError)
+	g.Register(fs)
+	var o options
+	o.register(g, fs)
+	addr := fs.String("addr", ":9000", "The address to listen on")
+	grpcAddr := fs.String("grpc-addr", "", "The address to answer Envoy's ext_authz on as well, the envoy.service.auth.v3.Authorization gRPC service")
+	ttl := fs.Duration("cache-ttl", 5*time.Minute, "How long the keys, and each issuer's metadata, are kept before they're fetched again")
+	prefix := fs.String("header-prefix", "X-Jwt-", "Each claim of a valid token is returned in a response header named by this prefix and the claim, X-Jwt-Sub for sub. Empty for none")
+	var claimHeaders cli.Files
+	fs.Var(&claimHeaders, "claim-header", "claim=Header-Name returns the claim, or a dotted path into one, in that response header as well. Can be repeated")
+	cli.Usage(fs, "Usage: jwt-tools verify serve [--addr :9000] --jwks-url <url> | --jwks-file <file> | --key <file> | --cert <file> | --hmac-secret <secret> [options]",
+		"       jwt-tools verify serve [--addr :9000] --issuer <url> [--issuer <url> ...] [options]",
+		"       jwt-tools verify serve [--addr :9000] --trust-config trust.yaml [options]",
+		"       jwt-tools verify serve [--addr :9000] --grpc-addr :9001 [options]")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() > 0 {
+		cli.Fatal("verify serve reads the tokens from the requests, it takes no arguments")
+		return cli.ExitUsage
+	}
+	if err := g.Check(); err != nil {
+		cli.Fatal(err)
+		return cli.ExitUsage
+	}
+	if *ttl < 0 {
+		cli.Fatal("--cache-ttl can't be negative")
+		return cli.ExitUsage
+	}
+	if *prefix != "" && !headerName(*prefix) {
+		cli.Fatal("--header-prefix can only have letters, digits and dashes")
+		return cli.ExitUsage
+	}
+	// every request's answer rests on the keys, so they're never fetched without verifying TLS
+	if o.insecure {
+		cli.Fatal("verify serve doesn't take --insecure, name the CA of the key endpoints in SSL_CERT_FILE instead")
+		return cli.ExitUsage
+	}
+	policy, err := o.policy()
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitUsage
+	}
+	mapping, err := parseClaimHeaders(claimHeaders)
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitUsage
+	}
+	v, err := o.load(g, policy)
+	if err != nil {
+		cli.Fatal(err)
+		if errors.Is(err, jwttools.ErrKeySource) {
+			return cli.ExitKeys
+		}
+		return cli.ExitUsage
+	}
+	m := newMetrics()
+	v.ttl, v.metrics, v.routes = *ttl, m, map[string]route{}
+	v.source = v.cache(v.source)
+
+	s := &server{g: g, verifier: v, prefix: *prefix, mapping: mapping, metrics: m}
+	httpServer := &http.Server{Addr: *addr, Handler: s.mux(), ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	failed := make(chan error, 1)
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			cli.Fatal(err)
+			return cli.ExitUsage
+		}
+		grpcServer = grpc.NewServer()
+		authv3.RegisterAuthorizationServer(grpcServer, extAuthz{s})
+		g.Logf("Answering ext_authz on %s", *grpcAddr)
+		go func() {
+			failed <- grpcServer.Serve(listener)
+		}()
+	}
+	// on a signal the requests being answered are finished before exiting
+	drained := make(chan struct{})
+	var grpcErr error
+	go func() {
+		select {
+		case <-ctx.Done():
+		case grpcErr = <-failed:
+		}
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		close(drained)
+	}()
+	g.Logf("Listening on %s", *addr)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		cli.Fatal(err)
+		return cli.ExitUsage
+	}
+	<-drained
+	if grpcErr != nil {
+		cli.Fatal(grpcErr)
+		return cli.ExitUsage
+	}
+	return cli.ExitOK
+}
@@ -0,0 +1,197 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+const testSecret = "a secret that is long enough for HS256"
+
+// testServer is verify serve's endpoints for the options, with the test secret as the key unless
+// the globals are changed
+func testServer(t *testing.T, o options, change func(g *cli.Globals)) *httptest.Server {
+	t.Helper()
+	g := cli.NewGlobals()
+	g.HMACSecret = testSecret
+	if change != nil {
+		change(g)
+	}
+	policy, err := o.policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := o.load(g, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMetrics()
+	v.ttl, v.metrics, v.routes = time.Minute, m, map[string]route{}
+	v.source = v.cache(v.source)
+	s := &server{g: g, verifier: v, prefix: "X-Jwt-", mapping: []claimHeader{{"realm.role", "X-Role"}}, metrics: m}
+	server := httptest.NewServer(s.mux())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testToken(t *testing.T, claims jwttools.Claims, secret string) string {
+	t.Helper()
+	token, err := jwttools.Mint(context.Background(), claims, jwttools.NewHMACSigner([]byte(secret)), jwttools.MintOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// get asks the server about the token, giving the response and its body
+func get(t *testing.T, url, authorization string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestServe(t *testing.T) {
+	server := testServer(t, options{policyExprs: cli.Files{"scope contains orders:write"}}, nil)
+	valid := jwttools.Claims{"sub": "alice", "scope": "orders:write", "roles": []interface{}{"a", "b"}, "realm": map[string]interface{}{"role": "ops"}, "https://example.com/x": "y"}
+	expired := jwttools.Claims{"sub": "alice", "scope": "orders:write", "exp": float64(time.Now().Add(-time.Hour).Unix())}
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		code          string
+		challenge     string
+	}{
+		{"valid", "Bearer " + testToken(t, valid, testSecret), http.StatusOK, "", ""},
+		{"lower case scheme", "bearer " + testToken(t, valid, testSecret), http.StatusOK, "", ""},
+		{"no token", "", http.StatusUnauthorized, "missing_token", `Bearer realm="jwt"`},
+		{"basic", "Basic YTpi", http.StatusUnauthorized, "missing_token", `Bearer realm="jwt"`},
+		{"wrong key", "Bearer " + testToken(t, valid, "another secret that is long enough too"), http.StatusUnauthorized, "bad_signature", `error="invalid_token"`},
+		{"expired", "Bearer " + testToken(t, expired, testSecret), http.StatusUnauthorized, "invalid_exp", `error="invalid_token"`},
+		{"policy", "Bearer " + testToken(t, jwttools.Claims{"sub": "bob", "scope": "orders:read"}, testSecret), http.StatusForbidden, "policy_failed", `error="insufficient_scope"`},
+		{"malformed", "Bearer a.b", http.StatusUnauthorized, "malformed", `error="invalid_token"`},
+	}
+	for _, test := range tests {
+		resp, body := get(t, server.URL+"/auth", test.authorization)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got %d, want %d: %s", test.name, resp.StatusCode, test.status, body)
+			continue
+		}
+		if challenge := resp.Header.Get("WWW-Authenticate"); !strings.Contains(challenge, test.challenge) || (test.challenge == "") != (challenge == "") {
+			t.Errorf("%s: got WWW-Authenticate %q, want %q", test.name, challenge, test.challenge)
+		}
+		if test.status == http.StatusOK {
+			continue
+		}
+		var r report
+		if err := json.Unmarshal([]byte(body), &r); err != nil || len(r.Errors) != 1 || r.Errors[0].Code != test.code {
+			t.Errorf("%s: got %s, %v, want the code %s", test.name, body, err, test.code)
+		}
+		if test.code == "policy_failed" && (len(r.Rules) != 1 || r.Rules[0].OK) {
+			t.Errorf("%s: got rules %+v", test.name, r.Rules)
+		}
+	}
+}
+
+// The claims of a valid token are passed on in the headers, by the prefix and the --claim-header
+// mapping, and those of a rejected token never are
+func TestServeHeaders(t *testing.T) {
+	server := testServer(t, options{}, nil)
+	claims := jwttools.Claims{"sub": "alice", "roles": []interface{}{"a", "b"}, "realm": map[string]interface{}{"role": "ops"}, "level": float64(3), "https://example.com/x": "y"}
+	resp, _ := get(t, server.URL+"/", "Bearer "+testToken(t, claims, testSecret))
+	want := map[string]string{
+		"X-Jwt-Sub":   "alice",
+		"X-Jwt-Roles": "a,b",
+		"X-Jwt-Level": "3",
+		"X-Jwt-Realm": `{"role":"ops"}`,
+		"X-Role":      "ops",
+	}
+	for header, value := range want {
+		if got := resp.Header.Get(header); got != value {
+			t.Errorf("%s: got %q, want %q", header, got, value)
+		}
+	}
+	for header := range resp.Header {
+		if strings.Contains(header, "Example") || strings.Contains(header, "_") {
+			t.Errorf("a claim that isn't a header name was passed on as %s", header)
+		}
+	}
+
+	resp, _ = get(t, server.URL+"/", "Bearer "+testToken(t, claims, "another secret that is long enough too"))
+	for header := range resp.Header {
+		if strings.HasPrefix(header, "X-Jwt-") || header == "X-Role" {
+			t.Errorf("a rejected token's claim was passed on as %s", header)
+		}
+	}
+}
+
+// Keys that can't be had are 503, the proxy's error rather than a denial
+func TestServeKeysUnavailable(t *testing.T) {
+	server := testServer(t, options{}, func(g *cli.Globals) {
+		g.HMACSecret = ""
+		g.JWKSFile = filepath.Join(t.TempDir(), "missing.json")
+	})
+	resp, body := get(t, server.URL+"/", "Bearer "+testToken(t, jwttools.Claims{"sub": "alice"}, testSecret))
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "key_retrieval") {
+		t.Errorf("got %d %s, want 503 key_retrieval", resp.StatusCode, body)
+	}
+}
+
+// /metrics counts each answer by its status and code, and the keys being fetched, which is once
+// while they're cached
+func TestServeMetrics(t *testing.T) {
+	server := testServer(t, options{}, nil)
+	token := "Bearer " + testToken(t, jwttools.Claims{"sub": "alice"}, testSecret)
+	for _, authorization := range []string{token, token, token, "", "Bearer a.b"} {
+		get(t, server.URL+"/", authorization)
+	}
+	if resp, body := get(t, server.URL+"/healthz", ""); resp.StatusCode != http.StatusOK || body != "ok\n" {
+		t.Errorf("/healthz: got %d %q", resp.StatusCode, body)
+	}
+	resp, body := get(t, server.URL+"/metrics", "")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("/metrics: got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, sample := range []string{
+		`jwt_verify_requests_total{protocol="http",status="200",code="ok"} 3`,
+		`jwt_verify_requests_total{protocol="http",status="401",code="missing_token"} 1`,
+		`jwt_verify_requests_total{protocol="http",status="401",code="malformed"} 1`,
+		`jwt_verify_key_fetches_total{result="ok"} 1`,
+		`jwt_verify_request_duration_seconds_bucket{le="+Inf"} 5`,
+		`jwt_verify_request_duration_seconds_count 5`,
+	} {
+		if !strings.Contains(body, sample+"\n") {
+			t.Errorf("/metrics doesn't have %s:\n%s", sample, body)
+		}
+	}
+}
+
+// serve refuses --insecure before it listens, every answer rests on the keys
+func TestServeRefusesInsecure(t *testing.T) {
+	if code := serve(cli.NewGlobals(), []string{"--insecure", "--hmac-secret", testSecret, "--addr", "127.0.0.1:0"}); code != cli.ExitUsage {
+		t.Errorf("got %d, want %d", code, cli.ExitUsage)
+	}
+}
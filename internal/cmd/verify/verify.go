@@ -0,0 +1,450 @@
+// Package verify is the verify subcommand, formerly check-jwt. It checks a JWT's signature against
+// the keys from the global key flags and validates its exp, nbf and iat
+package verify
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// report is the --output json and yaml form of the result. valid, header, claims, key and errors
+// are always there, null or empty when there's nothing to say, so scripts can rely on them
+type report struct {
+	Valid      bool                   `json:"valid"`
+	Header     map[string]interface{} `json:"header"` // read without verifying it when the token isn't valid
+	Claims     map[string]interface{} `json:"claims"` // only when the token is valid
+	Key        *keyReport             `json:"key"`    // the key that verified the token
+	Errors     []errorReport          `json:"errors"`
+	Encryption map[string]interface{} `json:"encryption,omitempty"`
+	Rules      []jwttools.RuleResult  `json:"rules,omitempty"` // with --policy-expr or --policy-file
+	Steps      []jwttools.Step        `json:"steps,omitempty"` // with --explain
+}
+
+type keyReport struct {
+	KeyID      string `json:"kid"`
+	Thumbprint string `json:"thumbprint"` // RFC 7638, SHA-256
+}
+
+type errorReport struct {
+	Code    string `json:"code"` // from jwttools.ErrorCode
+	Kind    string `json:"kind"` // signature, claims, keys or malformed, which gives the exit code
+	Message string `json:"message"`
+}
+
+// exitCodes are the exit code for each kind of reason a token is rejected
+var exitCodes = map[string]int{
+	jwttools.KindSignature: cli.ExitSignature,
+	jwttools.KindClaims:    cli.ExitClaims,
+	jwttools.KindKeys:      cli.ExitKeys,
+	jwttools.KindMalformed: cli.ExitMalformed,
+}
+
+// explainer prints each step as it's taken in text, and collects them for the report otherwise
+type explainer struct {
+	structured bool
+	steps      []jwttools.Step
+}
+
+func (e *explainer) step(step jwttools.Step) {
+	if e.structured {
+		e.steps = append(e.steps, step)
+		return
+	}
+	result := "PASS"
+	if !step.OK {
+		result = "FAIL"
+	}
+	fmt.Printf("%s %s: %s\n", result, step.Name, step.Detail)
+}
+
+// explain gives a step to the policy's Explain when it has one
+func explain(policy jwttools.Policy, name string, ok bool, detail string) {
+	if policy.Explain != nil {
+		policy.Explain(jwttools.Step{Name: name, OK: ok, Detail: detail})
+	}
+}
+
+// parseAlgs splits the --algs list, refusing algs that can't be verified
+func parseAlgs(list string) ([]string, error) {
+	var algs []string
+	for _, alg := range strings.Split(list, ",") {
+		alg = strings.TrimSpace(alg)
+		switch {
+		case alg == "":
+			continue
+		case alg != "none" && !jwttools.AlgAllowed(alg, nil):
+			return nil, fmt.Errorf("unknown alg %q in --algs", alg)
+		}
+		algs = append(algs, alg)
+	}
+	return algs, nil
+}
+
+// loadTrustBundle reads the CA certificates in a file
+func loadTrustBundle(filename string) (*x509.CertPool, error) {
+	certs, err := keys.LoadCertificates(filename, "")
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	for _, c := range certs {
+		roots.AddCert(c)
+	}
+	return roots, nil
+}
+
+// options are the flags verify and verify serve share, saying where the keys are beyond the global
+// key flags and what a token has to satisfy
+type options struct {
+	issuers     cli.Files
+	trustConfig string
+	trustBundle string
+	policyExprs cli.Files
+	policyFile  string
+	algs        string
+	insecure    bool
+	x5uPrefixes cli.Files
+}
+
+func (o *options) register(g *cli.Globals, fs *flag.FlagSet) {
+	// check-jwt's name for --jwks-url
+	fs.StringVar(&g.JWKSURL, "jwksURL", g.JWKSURL, "URL of the JWKS service to retrieve the key from, the same as --jwks-url")
+	fs.Var(&o.issuers, "issuer", "An issuer to trust, its jwks_uri and algs are read from its OpenID Connect discovery document or RFC 8414 metadata and the token's iss has to be it. Can be repeated, the token's iss picks which one")
+	fs.StringVar(&o.trustConfig, "trust-config", "", "A YAML or JSON file mapping each trusted iss to its keys, audiences, algs, required claims and leeway. The token's iss picks which one")
+	fs.StringVar(&o.trustBundle, "trust-bundle", "", "PEM CA certificates. A token with an x5c or x5u header chaining to one of them is verified with the certificate's key, and keys with an x5c have to chain to one")
+	fs.Var(&o.x5uPrefixes, "x5u-prefix", "An https URL prefix, such as https://pki.example.com/certs/, that a token's x5u can be fetched from with --trust-bundle. Can be repeated, an x5u is ignored without one")
+	fs.Var(&o.policyExprs, "policy-expr", "A rule the claims have to satisfy, such as 'scope contains orders:write' or 'tenant in [a, b]'. Can be repeated")
+	fs.StringVar(&o.policyFile, "policy-file", "", "A file of rules the claims have to satisfy, one to a line")
+	fs.StringVar(&o.algs, "algs", "", "Comma separated algs the token can be signed with, e.g. RS256,ES256 (default any but none). none is only accepted when it's listed")
+	fs.BoolVar(&o.insecure, "insecure", false, "Don't verify the TLS certificates of the JWKS and discovery endpoints. Anyone who can intercept the connection can then give their own keys")
+}
+
+// newClient is an HTTP client for fetching keys, metadata and certificates, verifying TLS
+// certificates unless it's insecure
+func newClient(insecure bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
+
+// policy is the policy the options give, before the keys are loaded
+func (o *options) policy() (jwttools.Policy, error) {
+	allowed, err := parseAlgs(o.algs)
+	if err != nil {
+		return jwttools.Policy{}, err
+	}
+	rules, err := jwttools.ParseRules(o.policyExprs)
+	if err != nil {
+		return jwttools.Policy{}, err
+	}
+	if o.policyFile != "" {
+		fromFile, err := jwttools.LoadRules(o.policyFile)
+		if err != nil {
+			return jwttools.Policy{}, err
+		}
+		rules = append(rules, fromFile...)
+	}
+	if o.trustConfig != "" && len(o.issuers) > 0 {
+		return jwttools.Policy{}, errors.New("--trust-config and --issuer can't be used together, put the issuers in the trust config")
+	}
+	for _, prefix := range o.x5uPrefixes {
+		if u, err := url.Parse(prefix); err != nil || u.Scheme != "https" || u.Host == "" {
+			return jwttools.Policy{}, fmt.Errorf("--x5u-prefix %q isn't an https URL", prefix)
+		}
+	}
+	if len(o.x5uPrefixes) > 0 && o.trustBundle == "" {
+		return jwttools.Policy{}, errors.New("--x5u-prefix needs --trust-bundle, an x5u's chain has to validate against it")
+	}
+	// the x5u is fetched from a URL the token names, so its TLS is verified even with --insecure
+	return jwttools.Policy{Algorithms: allowed, Rules: rules, X5UPrefixes: o.x5uPrefixes, Client: newClient(false)}, nil
+}
+
+// load reads the keys, decryption keys, trust config and trust bundle the options and globals
+// name. The keys not loading is an ErrKeySource, any other error, a trust config or trust bundle
+// that can't be read included, is options that can't be used
+func (o *options) load(g *cli.Globals, policy jwttools.Policy) (*verifier, error) {
+	v := &verifier{g: g, issuers: o.issuers, policy: policy, client: newClient(o.insecure)}
+	var err error
+	if o.trustConfig != "" {
+		if v.config, err = jwttools.LoadTrustConfig(o.trustConfig); err != nil {
+			return nil, fmt.Errorf("--trust-config: %w", err)
+		}
+	}
+	v.source, err = g.KeySource(v.client)
+	switch {
+	case err == nil && v.config != nil:
+		return nil, errors.New("the keys come from --trust-config, they can't be given as well")
+	case errors.Is(err, cli.ErrNoKeys) && (len(o.issuers) > 0 || v.config != nil || o.trustBundle != ""):
+		// only the keys of the certificates in the header, or those found later
+		v.source = jwttools.KeySources{}
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", jwttools.ErrKeySource, err)
+	}
+	if v.policy.DecryptionKeys, err = g.DecryptionKeys(); err != nil {
+		return nil, fmt.Errorf("%w: %v", jwttools.ErrKeySource, err)
+	}
+	if o.trustBundle != "" {
+		if v.policy.Roots, err = loadTrustBundle(o.trustBundle); err != nil {
+			return nil, fmt.Errorf("--trust-bundle: %w", err)
+		}
+	}
+	if o.insecure {
+		cli.Warning("--insecure: the TLS certificates of the JWKS and discovery endpoints aren't verified")
+	}
+	return v, nil
+}
+
+// verifier verifies tokens with what the options loaded. With --issuer or --trust-config the
+// token's iss picks the keys and narrows the policy
+type verifier struct {
+	g       *cli.Globals
+	client  *http.Client
+	source  jwttools.KeySource
+	issuers []string
+	config  jwttools.TrustConfig
+	policy  jwttools.Policy
+
+	// serve keeps the keys for ttl, and what it found for each issuer in routes
+	ttl     time.Duration
+	metrics *metrics
+	mu      sync.Mutex
+	routes  map[string]route
+}
+
+// route is the keys and policy for an issuer
+type route struct {
+	source  jwttools.KeySource
+	policy  jwttools.Policy
+	expires time.Time
+}
+
+// cache keeps the keys from the source for the ttl, counting each time they're fetched
+func (v *verifier) cache(source jwttools.KeySource) jwttools.KeySource {
+	source = countedKeys{source, v.metrics}
+	if v.ttl == 0 {
+		return source
+	}
+	return &jwttools.CachedKeys{Source: source, TTL: v.ttl}
+}
+
+// trusted are the issuers a token can be from
+func (v *verifier) trusted() []string {
+	if v.config != nil {
+		return v.config.Issuers()
+	}
+	return v.issuers
+}
+
+// route gives the keys to verify the token with and the policy it has to satisfy
+func (v *verifier) route(ctx context.Context, token string) (jwttools.KeySource, jwttools.Policy, error) {
+	switch {
+	case len(v.issuers) == 0 && v.config == nil:
+		return v.source, v.policy, nil
+	case v.routes == nil:
+		return v.resolve(ctx, token)
+	}
+	iss, err := jwttools.TrustedIssuer(token, v.trusted(), v.policy.DecryptionKeys...)
+	if err != nil {
+		return nil, v.policy, err
+	}
+	v.mu.Lock()
+	r, ok := v.routes[iss]
+	v.mu.Unlock()
+	if ok && time.Now().Before(r.expires) {
+		return r.source, r.policy, nil
+	}
+	source, policy, err := v.resolve(ctx, token)
+	if err != nil {
+		return nil, policy, err
+	}
+	r = route{source: v.cache(source), policy: policy, expires: time.Now().Add(v.ttl)}
+	v.mu.Lock()
+	v.routes[iss] = r
+	v.mu.Unlock()
+	return r.source, r.policy, nil
+}
+
+// resolve finds the keys and policy for the token's issuer, discovering its metadata or looking it
+// up in the trust config
+func (v *verifier) resolve(ctx context.Context, token string) (jwttools.KeySource, jwttools.Policy, error) {
+	if len(v.issuers) > 0 {
+		return discover(ctx, v.g, v.client, token, v.issuers, v.source, v.policy)
+	}
+	source, policy, err := v.config.Route(ctx, v.client, token, v.policy)
+	if err == nil {
+		v.g.Logf("Trusted as %s", policy.Issuer)
+	}
+	return source, policy, err
+}
+
+// verify verifies the token, giving the policy it was verified against. When the keys are cached
+// and none has the token's kid they're fetched again once, the issuer may have rotated its keys
+func (v *verifier) verify(ctx context.Context, token string) (*jwttools.Result, jwttools.Policy, error) {
+	source, policy, err := v.route(ctx, token)
+	if err != nil {
+		return nil, policy, err
+	}
+	result, err := jwttools.Verify(ctx, token, source, policy)
+	if cached, ok := source.(*jwttools.CachedKeys); ok && errors.Is(err, jwttools.ErrKidNotFound) && cached.Refresh() {
+		v.g.Logf("No key has the token's kid, fetching the keys again")
+		result, err = jwttools.Verify(ctx, token, source, policy)
+	}
+	return result, policy, err
+}
+
+// discover reads the metadata of the issuer the token's iss names, which has to be one of the
+// issuers, adding its keys to the others and restricting the policy to its iss and algs
+func discover(ctx context.Context, g *cli.Globals, client *http.Client, token string, issuers []string, source jwttools.KeySource, policy jwttools.Policy) (jwttools.KeySource, jwttools.Policy, error) {
+	iss, err := jwttools.TrustedIssuer(token, issuers, policy.DecryptionKeys...)
+	if err != nil {
+		explain(policy, "issuer", false, err.Error())
+		return nil, policy, err
+	}
+	explain(policy, "issuer", true, iss+" is trusted")
+	md, err := jwttools.Discover(ctx, client, iss)
+	if err != nil {
+		explain(policy, "discovery", false, err.Error())
+		return nil, policy, fmt.Errorf("%w: %v", jwttools.ErrKeySource, err)
+	}
+	g.Logf("Discovered %s, jwks_uri %s, algs %v", md.URL, md.JWKSURI, md.Algorithms)
+	explain(policy, "discovery", true, fmt.Sprintf("%s, jwks_uri %s, algs %v", md.URL, md.JWKSURI, md.Algorithms))
+	if policy, err = md.Restrict(policy); err != nil {
+		explain(policy, "discovery", false, err.Error())
+		return nil, policy, err
+	}
+	sources := jwttools.KeySources{md.KeySource(client)}
+	if source != nil {
+		sources = append(sources, source)
+	}
+	return sources, policy, nil
+}
+
+// flagExit is verify's exit code for an error from parsing the flags, -h is not an error
+func flagExit(err error) int {
+	if code := cli.FlagExit(err); code != cli.ExitError {
+		return code
+	}
+	return cli.ExitUsage
+}
+
+// fail reports why the token wasn't verified and gives the exit code for the kind of reason
+func fail(g *cli.Globals, e *explainer, token string, policy jwttools.Policy, err error) int {
+	code, kind := jwttools.ErrorCode(err)
+	if !g.Structured() {
+		cli.Fatal("Failed to verify token: ", err)
+		return exitCodes[kind]
+	}
+	r := report{Errors: []errorReport{{Code: code, Kind: kind, Message: err.Error()}}, Steps: e.steps}
+	var policyErr *jwttools.PolicyError
+	if errors.As(err, &policyErr) {
+		r.Rules = policyErr.Results
+	}
+	if token != "" {
+		if decoded, err := jwttools.Decode(token, policy.DecryptionKeys...); err == nil {
+			r.Header, r.Encryption = decoded.Header, decoded.Encryption
+		}
+	}
+	g.Write(os.Stdout, r)
+	return exitCodes[kind]
+}
+
+// succeed reports the verified token
+func succeed(g *cli.Globals, e *explainer, result *jwttools.Result) int {
+	if !g.Structured() {
+		if result.Encryption != nil {
+			g.Logf("Decrypted with %v and %v", result.Encryption["alg"], result.Encryption["enc"])
+		}
+		g.Logf("Verified with kid %q", result.KeyID)
+		for _, rule := range result.Rules {
+			g.Logf("Satisfied %s: %s", rule.Rule, rule.Detail)
+		}
+		for _, key := range cli.SortedKeys(result.Claims) {
+			fmt.Printf("%s\t%v\n", key, result.Claims[key])
+		}
+		return cli.ExitOK
+	}
+	r := report{Valid: true, Header: result.Header, Claims: result.Claims, Errors: []errorReport{}, Encryption: result.Encryption, Rules: result.Rules, Steps: e.steps}
+	if result.Key != nil {
+		thumbprint, err := result.Key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			cli.Fatal("Failed to take the key's thumbprint: ", err)
+			return cli.ExitKeys
+		}
+		r.Key = &keyReport{KeyID: result.KeyID, Thumbprint: base64.RawURLEncoding.EncodeToString(thumbprint)}
+	}
+	g.Write(os.Stdout, r)
+	return cli.ExitOK
+}
+
+// Main runs the subcommand and returns the exit code
+func Main(g *cli.Globals, args []string) int {
+	if len(args) > 0 && args[0] == "serve" {
+		return serve(g, args[1:])
+	}
+
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	g.Register(fs)
+	token := fs.String("token", "", "JWT token to verify, read from the first argument or stdin if not given")
+	var o options
+	o.register(g, fs)
+	explainSteps := fs.Bool("explain", false, "Print each step of the verification as it passes or fails: the header, the keys, the candidate keys, the signature and each claim check")
+	cli.Usage(fs, "Usage: jwt-tools verify --jwks-url <url> | --jwks-file <file> | --key <file> | --cert <file> | --hmac-secret <secret> [--decrypt-key key.pem] [--token] <token>",
+		"       jwt-tools verify --issuer <url> [--issuer <url> ...] [--token] <token>",
+		"       jwt-tools verify --trust-config trust.yaml [--token] <token>",
+		"       jwt-tools verify --trust-bundle ca.pem [--token] <token>",
+		"       jwt-tools verify serve [--addr :9000] [options]")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if err := g.Check(); err != nil {
+		cli.Fatal(err)
+		return cli.ExitUsage
+	}
+	policy, err := o.policy()
+	if err != nil {
+		cli.Fatal(err)
+		return cli.ExitUsage
+	}
+	e := &explainer{structured: g.Structured()}
+	tokenString, err := cli.ReadToken(*token, fs.Args())
+	if err != nil {
+		return fail(g, e, "", jwttools.Policy{}, fmt.Errorf("%w: %v", jwttools.ErrMalformed, err))
+	}
+	v, err := o.load(g, policy)
+	switch {
+	case errors.Is(err, jwttools.ErrKeySource):
+		return fail(g, e, "", jwttools.Policy{}, err)
+	case err != nil:
+		cli.Fatal(err)
+		return cli.ExitUsage
+	}
+	if *explainSteps {
+		v.policy.Explain = e.step
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, policy, err := v.verify(ctx, tokenString)
+	if err != nil {
+		return fail(g, e, tokenString, policy, err)
+	}
+	return succeed(g, e, result)
+}
@@ -0,0 +1,134 @@
+package verify
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/jwttools"
+)
+
+// The keys are fetched with TLS verified unless --insecure is given, and --insecure only changes
+// the client it's given to, not http.DefaultTransport that everything else uses
+func TestNewClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	if resp, err := newClient(false).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("the default client accepted a self signed certificate")
+	}
+	insecure := newClient(true)
+	resp, err := insecure.Get(server.URL)
+	if err != nil {
+		t.Fatalf("the --insecure client: %v", err)
+	}
+	resp.Body.Close()
+
+	if config := http.DefaultTransport.(*http.Transport).TLSClientConfig; config != nil && config.InsecureSkipVerify {
+		t.Errorf("--insecure changed http.DefaultTransport")
+	}
+	if resp, err := http.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("http.DefaultClient accepted a self signed certificate after an --insecure client was made")
+	}
+	if resp, err := newClient(false).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("a client made after an --insecure one accepted a self signed certificate")
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: wrong key", jwttools.ErrSignature), cli.ExitSignature},
+		{fmt.Errorf("%w: %q", jwttools.ErrKidNotFound, "a"), cli.ExitSignature},
+		{fmt.Errorf("%w: RSA1_5", jwttools.ErrAlgNotAllowed), cli.ExitSignature},
+		{&jwttools.ClaimError{Claim: "exp", Err: errors.New("expired")}, cli.ExitClaims},
+		{&jwttools.PolicyError{}, cli.ExitClaims},
+		{jwttools.ErrUntrustedIssuer, cli.ExitClaims},
+		{fmt.Errorf("%w: unreachable", jwttools.ErrKeySource), cli.ExitKeys},
+		{fmt.Errorf("%w: two parts", jwttools.ErrMalformed), cli.ExitMalformed},
+	}
+	for _, test := range tests {
+		_, kind := jwttools.ErrorCode(test.err)
+		if got := exitCodes[kind]; got != test.want {
+			t.Errorf("%v exits %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+// Each kind of reason has its own exit code, and none of them is success or bad options
+func TestExitCodesDistinct(t *testing.T) {
+	seen := map[int]string{cli.ExitOK: "ok", cli.ExitUsage: "usage"}
+	for _, kind := range []string{jwttools.KindSignature, jwttools.KindClaims, jwttools.KindKeys, jwttools.KindMalformed} {
+		code, ok := exitCodes[kind]
+		if !ok {
+			t.Errorf("%s has no exit code", kind)
+			continue
+		}
+		if other, taken := seen[code]; taken {
+			t.Errorf("%s and %s both exit %d", kind, other, code)
+		}
+		seen[code] = kind
+	}
+}
+
+func TestFlagExit(t *testing.T) {
+	if code := flagExit(flag.ErrHelp); code != cli.ExitOK {
+		t.Errorf("-h exits %d, want %d", code, cli.ExitOK)
+	}
+	if code := flagExit(errors.New("flag provided but not defined: -x")); code != cli.ExitUsage {
+		t.Errorf("a bad flag exits %d, want %d", code, cli.ExitUsage)
+	}
+}
+
+// Options that can't be used exit 5 rather than 2, which would read as a failed claim check
+func TestMainUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"--no-such-flag"},
+		{"--algs", "RS256,XX1", "a.b.c"},
+		{"--trust-config", "trust.yaml", "--issuer", "https://idp.example.com", "a.b.c"},
+		{"--trust-config", filepath.Join(t.TempDir(), "missing.yaml"), "a.b.c"},
+		{"--trust-bundle", filepath.Join(t.TempDir(), "missing.pem"), "a.b.c"},
+		{"--trust-bundle", "ca.pem", "--x5u-prefix", "http://pki.example.com/", "a.b.c"},
+		{"--x5u-prefix", "https://pki.example.com/", "a.b.c"},
+		{"--policy-expr", "scope between a", "a.b.c"},
+		{"--policy-expr", "roles contains #admin", "a.b.c"},
+	} {
+		if code := Main(cli.NewGlobals(), args); code != cli.ExitUsage {
+			t.Errorf("%v exits %d, want %d", args, code, cli.ExitUsage)
+		}
+	}
+}
+
+func TestOptionsErrors(t *testing.T) {
+	tests := []options{
+		{algs: "RS256,XX1"},
+		{policyExprs: cli.Files{"scope between a"}},
+		{trustConfig: "trust.yaml", issuers: cli.Files{"https://idp.example.com"}},
+		{trustBundle: "ca.pem", x5uPrefixes: cli.Files{"http://pki.example.com/"}},
+		{x5uPrefixes: cli.Files{"https://pki.example.com/"}},
+	}
+	for _, o := range tests {
+		if _, err := o.policy(); err == nil {
+			t.Errorf("%+v gave a policy", o)
+		}
+	}
+	policy, err := (&options{trustBundle: "ca.pem", x5uPrefixes: cli.Files{"https://pki.example.com/certs/"}}).policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Client == nil || len(policy.X5UPrefixes) != 1 {
+		t.Errorf("the policy doesn't have the x5u prefix and a client to fetch it with")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+/* jwt-tools is every tool in one binary, as subcommands that share the global flags, the jwttools
+   library and the exit codes. A token minted by one subcommand verifies with another
+*/
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/internal/cmd/decode"
+	"github.com/ps258/jwt-tools/internal/cmd/jwks"
+	"github.com/ps258/jwt-tools/internal/cmd/keygen"
+	"github.com/ps258/jwt-tools/internal/cmd/load"
+	"github.com/ps258/jwt-tools/internal/cmd/mint"
+	"github.com/ps258/jwt-tools/internal/cmd/verify"
+)
+
+// command is one subcommand
+type command struct {
+	name    string
+	summary string
+	main    func(g *cli.Globals, args []string) int
+}
+
+var commands = []command{
+	{"mint", "Sign a JWT, formerly mk-jwt", mint.Main},
+	{"verify", "Verify a JWT's signature and claims, formerly check-jwt", verify.Main},
+	{"decode", "Print a JWT's header and claims without verifying it", decode.Main},
+	{"jwks", "Make a JWKS from keys and certificates, or compare two, formerly mk-jwks", jwks.Main},
+	{"load", "Call an API with a new JWT for each request, formerly load-jwt", load.Main},
+	{"keys", "Generate keys and certificates, formerly mk-keys", keygen.Main},
+}
+
+func main() {
+	g := cli.NewGlobals()
+	fs := flag.NewFlagSet("jwt-tools", flag.ContinueOnError)
+	g.Register(fs)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "Usage: jwt-tools [global options] <command> [options]")
+		fmt.Fprintln(out, "\nCommands:")
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-8s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintln(out, "\nGlobal options, which can also be given after the command:")
+		fs.PrintDefaults()
+		fmt.Fprintln(out, "\nExit codes: 0 success, 1 the token didn't verify or the JWKS differ, 2 any other error")
+		fmt.Fprintln(out, "verify's say why: 1 the signature, 2 a claim, 3 the keys, 4 the token couldn't be read, 5 bad options")
+	}
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(cli.FlagExit(err))
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(cli.ExitError)
+	}
+
+	name := fs.Arg(0)
+	if name == "help" {
+		fs.Usage()
+		os.Exit(cli.ExitOK)
+	}
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.main(g, fs.Args()[1:]))
+		}
+	}
+	cli.Fatal("Unknown command " + name)
+	fs.Usage()
+	os.Exit(cli.ExitError)
+}
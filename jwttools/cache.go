@@ -0,0 +1,52 @@
+package jwttools
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// minRefresh is how soon after a fetch CachedKeys.Refresh will let the keys be fetched again
+const minRefresh = 10 * time.Second
+
+// CachedKeys keeps the keys from a source for TTL, so that a server verifying many tokens doesn't
+// fetch the JWKS for each of them. Keys that couldn't be had aren't kept, the next KeySet tries
+// again. It's safe to use from several goroutines
+type CachedKeys struct {
+	Source KeySource
+	TTL    time.Duration
+
+	mu      sync.Mutex
+	set     jwk.Set
+	fetched time.Time
+}
+
+func (c *CachedKeys) KeySet(ctx context.Context) (jwk.Set, error) {
+	// the lock is held while fetching so that tokens arriving together wait for the one fetch
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.set != nil && time.Since(c.fetched) < c.TTL {
+		return c.set, nil
+	}
+	set, err := c.Source.KeySet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.set, c.fetched = set, time.Now()
+	return set, nil
+}
+
+// Refresh drops the keys so that the next KeySet fetches them, for a token with a kid that isn't
+// in them because the issuer has rotated its keys. Within minRefresh of the last fetch it does
+// nothing and returns false, so tokens with made up kids can't make it fetch for every one
+func (c *CachedKeys) Refresh() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.set == nil || time.Since(c.fetched) < minRefresh {
+		return false
+	}
+	c.set = nil
+	return true
+}
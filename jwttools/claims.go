@@ -0,0 +1,109 @@
+package jwttools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitPath splits a dotted claim path into the names in it. A name with dots in it, such as
+// "https://example.com/roles", is quoted with double or single quotes
+func splitPath(path string) ([]string, error) {
+	var names []string
+	rest := path
+	for {
+		var name string
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			end := strings.IndexByte(rest[1:], rest[0])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in claim path %q", path)
+			}
+			name, rest = rest[1:end+1], rest[end+2:]
+			if rest != "" && rest[0] != '.' {
+				return nil, fmt.Errorf("invalid claim path %q", path)
+			}
+		} else if i := strings.IndexByte(rest, '.'); i >= 0 {
+			name, rest = rest[:i], rest[i:]
+		} else {
+			name, rest = rest, ""
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid claim path %q", path)
+		}
+		names = append(names, name)
+		if rest == "" {
+			return names, nil
+		}
+		// past the dot
+		rest = rest[1:]
+	}
+}
+
+// SetPath sets a claim given a dotted path, so ctx.tenant sets the tenant member of the ctx
+// object, creating ctx if it isn't there. A name with dots in it is quoted,
+// '"https://example.com/roles"' or ctx."a.b"
+func (c Claims) SetPath(path string, value interface{}) error {
+	names, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+	obj := map[string]interface{}(c)
+	for i, name := range names[:len(names)-1] {
+		switch next := obj[name].(type) {
+		case map[string]interface{}:
+			obj = next
+		case Claims:
+			obj = next
+		case nil:
+			created := map[string]interface{}{}
+			obj[name] = created
+			obj = created
+		default:
+			return fmt.Errorf("can't set %s, %s is a %T not an object", path, strings.Join(names[:i+1], "."), next)
+		}
+	}
+	obj[names[len(names)-1]] = value
+	return nil
+}
+
+// Copy copies the claims and every object and array in them
+func (c Claims) Copy() Claims {
+	return Claims(copyValue(map[string]interface{}(c)).(map[string]interface{}))
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case Claims:
+		return v.Copy()
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			copied[name] = copyValue(member)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, member := range v {
+			copied[i] = copyValue(member)
+		}
+		return copied
+	}
+	return value
+}
+
+// Merge layers other on top of c. Objects in both are merged member by member, anything else in
+// other replaces what's in c
+func (c Claims) Merge(other Claims) {
+	mergeObjects(c, other)
+}
+
+func mergeObjects(dst, src map[string]interface{}) {
+	for name, value := range src {
+		srcObj, srcIsObj := value.(map[string]interface{})
+		dstObj, dstIsObj := dst[name].(map[string]interface{})
+		if srcIsObj && dstIsObj {
+			mergeObjects(dstObj, srcObj)
+			continue
+		}
+		dst[name] = value
+	}
+}
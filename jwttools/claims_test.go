@@ -0,0 +1,83 @@
+package jwttools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string // nil when the path is invalid
+	}{
+		{"sub", []string{"sub"}},
+		{"ctx.tenant", []string{"ctx", "tenant"}},
+		{`"https://example.com/roles"`, []string{"https://example.com/roles"}},
+		{`'a.b'.c`, []string{"a.b", "c"}},
+		{`ctx."a.b"`, []string{"ctx", "a.b"}},
+		{`ctx."a'b".c`, []string{"ctx", "a'b", "c"}},
+		{"", nil},
+		{"a..b", nil},
+		{"a.", nil},
+		{".a", nil},
+		{`"a.b`, nil},
+		{`"a"b`, nil},
+		{`""`, nil},
+	}
+	for _, test := range tests {
+		names, err := splitPath(test.path)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("splitPath(%q) = %q, want an error", test.path, names)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(names, test.want) {
+			t.Errorf("splitPath(%q) = %q, %v, want %q", test.path, names, err, test.want)
+		}
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	claims := Claims{"sub": "alice", "ctx": map[string]interface{}{"tenant": "a"}}
+	for path, value := range map[string]interface{}{
+		"ctx.region":                  "eu",
+		"new.deep.member":             true,
+		`"https://example.com/roles"`: []interface{}{"admin"},
+	} {
+		if err := claims.SetPath(path, value); err != nil {
+			t.Fatalf("SetPath(%q): %v", path, err)
+		}
+	}
+	want := Claims{
+		"sub":                       "alice",
+		"ctx":                       map[string]interface{}{"tenant": "a", "region": "eu"},
+		"new":                       map[string]interface{}{"deep": map[string]interface{}{"member": true}},
+		"https://example.com/roles": []interface{}{"admin"},
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Errorf("got %v, want %v", claims, want)
+	}
+	if err := claims.SetPath("sub.name", "x"); err == nil {
+		t.Errorf("set a member of a string claim")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	claims := Claims{"sub": "alice", "ctx": map[string]interface{}{"tenant": "a", "region": "eu"}, "roles": []interface{}{"a"}}
+	claims.Merge(Claims{"sub": "bob", "ctx": map[string]interface{}{"tenant": "b"}, "roles": []interface{}{"b"}})
+	want := Claims{"sub": "bob", "ctx": map[string]interface{}{"tenant": "b", "region": "eu"}, "roles": []interface{}{"b"}}
+	if !reflect.DeepEqual(claims, want) {
+		t.Errorf("got %v, want %v", claims, want)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	claims := Claims{"ctx": map[string]interface{}{"tenant": "a"}, "roles": []interface{}{"a"}}
+	copied := claims.Copy()
+	copied["ctx"].(map[string]interface{})["tenant"] = "b"
+	copied["roles"].([]interface{})[0] = "b"
+	if !reflect.DeepEqual(claims, Claims{"ctx": map[string]interface{}{"tenant": "a"}, "roles": []interface{}{"a"}}) {
+		t.Errorf("changing the copy changed the claims: %v", claims)
+	}
+}
@@ -0,0 +1,50 @@
+package jwttools
+
+import (
+	"errors"
+	"strings"
+)
+
+// The kinds of reason Verify rejects a token for
+const (
+	KindSignature = "signature" // the signature didn't verify, or no key was allowed to verify it
+	KindClaims    = "claims"    // the signature verified but a claim check failed
+	KindKeys      = "keys"      // the keys couldn't be had
+	KindMalformed = "malformed" // the token couldn't be read
+)
+
+// errorCodes are the code and kind of each of the errors Verify returns, most specific first
+var errorCodes = []struct {
+	err  error
+	code string
+	kind string
+}{
+	{ErrKeySource, "key_retrieval", KindKeys},
+	{ErrMalformed, "malformed", KindMalformed},
+	{ErrUntrustedIssuer, "untrusted_issuer", KindClaims},
+	{ErrPolicy, "policy_failed", KindClaims},
+	{ErrAlgNotAllowed, "alg_not_allowed", KindSignature},
+	{ErrUnknownCritical, "unknown_crit", KindSignature},
+	{ErrKidNotFound, "kid_not_found", KindSignature},
+	{ErrAlgMismatch, "alg_mismatch", KindSignature},
+	{ErrNoCompatibleKey, "no_compatible_key", KindSignature},
+	{ErrCertificate, "bad_certificate", KindSignature},
+	{ErrSignature, "bad_signature", KindSignature},
+	{ErrDecrypt, "decryption_failed", KindSignature},
+}
+
+// ErrorCode is a short code that won't change for an error from Verify, and the kind of reason it
+// is. A failed claim check is invalid_ and the claim, invalid_exp say. Anything else is rejected,
+// a signature kind of reason
+func ErrorCode(err error) (code, kind string) {
+	var claimErr *ClaimError
+	if errors.As(err, &claimErr) {
+		return "invalid_" + strings.ReplaceAll(claimErr.Claim, " ", "_"), KindClaims
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code, c.kind
+		}
+	}
+	return "rejected", KindSignature
+}
@@ -0,0 +1,48 @@
+package jwttools
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+		kind string
+	}{
+		{fmt.Errorf("%w: unreachable", ErrKeySource), "key_retrieval", KindKeys},
+		{fmt.Errorf("%w: three parts", ErrMalformed), "malformed", KindMalformed},
+		{ErrUntrustedIssuer, "untrusted_issuer", KindClaims},
+		{&PolicyError{}, "policy_failed", KindClaims},
+		{&ClaimError{Claim: "exp", Err: errors.New("expired")}, "invalid_exp", KindClaims},
+		{fmt.Errorf("wrapped: %w", &ClaimError{Claim: "required claims", Err: errors.New("missing")}), "invalid_required_claims", KindClaims},
+		{fmt.Errorf("%w: RSA1_5", ErrAlgNotAllowed), "alg_not_allowed", KindSignature},
+		{ErrUnknownCritical, "unknown_crit", KindSignature},
+		{fmt.Errorf("%w: %q", ErrKidNotFound, "a"), "kid_not_found", KindSignature},
+		{ErrAlgMismatch, "alg_mismatch", KindSignature},
+		{ErrNoCompatibleKey, "no_compatible_key", KindSignature},
+		{ErrCertificate, "bad_certificate", KindSignature},
+		{ErrSignature, "bad_signature", KindSignature},
+		{ErrDecrypt, "decryption_failed", KindSignature},
+		{errors.New("anything else"), "rejected", KindSignature},
+	}
+	for _, test := range tests {
+		code, kind := ErrorCode(test.err)
+		if code != test.code || kind != test.kind {
+			t.Errorf("ErrorCode(%v) = %s, %s, want %s, %s", test.err, code, kind, test.code, test.kind)
+		}
+	}
+}
+
+// Every error in the table has a code of its own, scripts tell them apart by it
+func TestErrorCodesUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, c := range errorCodes {
+		if seen[c.code] {
+			t.Errorf("%s is the code of more than one error", c.code)
+		}
+		seen[c.code] = true
+	}
+}
@@ -0,0 +1,154 @@
+package jwttools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrUntrustedIssuer is returned by TrustedIssuer when the token's iss isn't one of the issuers
+var ErrUntrustedIssuer = errors.New("the token's issuer is not trusted")
+
+// Metadata is what Discover reads from an issuer's OpenID Connect discovery document or OAuth
+// authorization server metadata
+type Metadata struct {
+	Issuer     string   `json:"issuer"`
+	JWKSURI    string   `json:"jwks_uri"`
+	Algorithms []string `json:"id_token_signing_alg_values_supported"`
+	URL        string   `json:"-"` // where the metadata was read from
+}
+
+// discoveryURLs are where the metadata for an issuer can be: the OpenID Connect location, the
+// issuer with /.well-known/openid-configuration on the end, then RFC 8414's, with
+// /.well-known/oauth-authorization-server in front of the issuer's path
+func discoveryURLs(issuer string) ([]string, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("the issuer %q is not a URL", issuer)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	oidc := *u
+	oidc.Path = path + "/.well-known/openid-configuration"
+	oauth := *u
+	oauth.Path = "/.well-known/oauth-authorization-server" + path
+	return []string{oidc.String(), oauth.String()}, nil
+}
+
+// Discover reads the metadata of an issuer, trying the OpenID Connect location first and RFC
+// 8414's when that isn't found. The issuer in the metadata has to be exactly the issuer asked for
+// and there has to be a jwks_uri
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Metadata, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	locations, err := discoveryURLs(issuer)
+	if err != nil {
+		return nil, err
+	}
+	var md *Metadata
+	for _, location := range locations {
+		if md, err = fetchMetadata(ctx, client, location); err == nil || !errors.Is(err, errNotFound) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if md.Issuer != issuer {
+		return nil, fmt.Errorf("%s: the issuer is %q, not %q", md.URL, md.Issuer, issuer)
+	}
+	if md.JWKSURI == "" {
+		return nil, fmt.Errorf("%s: there is no jwks_uri", md.URL)
+	}
+	return md, nil
+}
+
+var errNotFound = errors.New("not found")
+
+func fetchMetadata(ctx context.Context, client *http.Client, location string) (*Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", location, errNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	md := &Metadata{URL: location}
+	if err := json.Unmarshal(body, md); err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	return md, nil
+}
+
+// KeySource is the issuer's JWKS
+func (md *Metadata) KeySource(client *http.Client) KeySource {
+	return JWKSURL{URL: md.JWKSURI, Client: client}
+}
+
+// Restrict narrows the policy to the issuer: the iss has to be the issuer and the alg one of the
+// algs it signs with, and of the policy's algs if it has any. It fails when none are left
+func (md *Metadata) Restrict(policy Policy) (Policy, error) {
+	policy.Issuer = md.Issuer
+	return restrictAlgs(policy, md.Issuer, md.Algorithms)
+}
+
+// restrictAlgs narrows the policy's algs to the ones the issuer signs with, all of them if the
+// policy doesn't have any and none of them if the issuer's aren't known
+func restrictAlgs(policy Policy, issuer string, algs []string) (Policy, error) {
+	if len(algs) == 0 {
+		return policy, nil
+	}
+	if len(policy.Algorithms) == 0 {
+		policy.Algorithms = algs
+		return policy, nil
+	}
+	var both []string
+	for _, alg := range policy.Algorithms {
+		if contains(algs, alg) {
+			both = append(both, alg)
+		}
+	}
+	if len(both) == 0 {
+		return policy, fmt.Errorf("%w: none of the algs allowed are ones %s signs with, %s", ErrAlgNotAllowed, issuer, strings.Join(algs, ", "))
+	}
+	policy.Algorithms = both
+	return policy, nil
+}
+
+// TrustedIssuer is the token's iss, read without verifying the token, if it's one of the trusted
+// issuers. An encrypted token is decrypted with the keys to read it
+func TrustedIssuer(token string, trusted []string, decryptionKeys ...interface{}) (string, error) {
+	result, err := Decode(token, decryptionKeys...)
+	if err != nil {
+		return "", err
+	}
+	iss, _ := result.Claims["iss"].(string)
+	if iss == "" {
+		return "", fmt.Errorf("%w: it has no iss", ErrUntrustedIssuer)
+	}
+	if !contains(trusted, iss) {
+		return "", fmt.Errorf("%w: %q", ErrUntrustedIssuer, iss)
+	}
+	return iss, nil
+}
@@ -0,0 +1,94 @@
+package jwttools
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// testIssuer serves an issuer's metadata at the OpenID Connect or RFC 8414 location
+func testIssuer(t *testing.T, path, metadata string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, metadata, server.URL)
+	})
+	return server
+}
+
+func TestDiscover(t *testing.T) {
+	ctx := context.Background()
+	oidc := testIssuer(t, "/realm/.well-known/openid-configuration",
+		`{"issuer":"%[1]s/realm","jwks_uri":"%[1]s/realm/jwks","id_token_signing_alg_values_supported":["RS256","ES256"]}`)
+	md, err := Discover(ctx, oidc.Client(), oidc.URL+"/realm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.JWKSURI != oidc.URL+"/realm/jwks" || !reflect.DeepEqual(md.Algorithms, []string{"RS256", "ES256"}) {
+		t.Errorf("got %+v", md)
+	}
+
+	oauth := testIssuer(t, "/.well-known/oauth-authorization-server/realm", `{"issuer":"%[1]s/realm","jwks_uri":"%[1]s/jwks"}`)
+	if md, err := Discover(ctx, oauth.Client(), oauth.URL+"/realm"); err != nil || md.URL != oauth.URL+"/.well-known/oauth-authorization-server/realm" {
+		t.Errorf("RFC 8414: got %+v, %v", md, err)
+	}
+
+	other := testIssuer(t, "/.well-known/openid-configuration", `{"issuer":"https://idp.example.com","jwks_uri":"%s/jwks"}`)
+	if _, err := Discover(ctx, other.Client(), other.URL); err == nil {
+		t.Errorf("accepted metadata for another issuer")
+	}
+	noKeys := testIssuer(t, "/.well-known/openid-configuration", `{"issuer":"%s"}`)
+	if _, err := Discover(ctx, noKeys.Client(), noKeys.URL); err == nil {
+		t.Errorf("accepted metadata without a jwks_uri")
+	}
+	if _, err := Discover(ctx, nil, oidc.URL+"/realm"); err == nil {
+		t.Errorf("accepted a self signed certificate with the default client")
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	md := &Metadata{Issuer: "https://idp.example.com", Algorithms: []string{"RS256", "ES256"}}
+	tests := []struct {
+		allowed []string
+		want    []string
+	}{
+		{nil, []string{"RS256", "ES256"}},
+		{[]string{"ES256", "PS256"}, []string{"ES256"}},
+		{[]string{"PS256"}, nil},
+	}
+	for _, test := range tests {
+		policy, err := md.Restrict(Policy{Algorithms: test.allowed})
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%v: got %v, want an error", test.allowed, policy.Algorithms)
+			}
+			continue
+		}
+		if err != nil || policy.Issuer != md.Issuer || !reflect.DeepEqual(policy.Algorithms, test.want) {
+			t.Errorf("%v: got %+v, %v", test.allowed, policy, err)
+		}
+	}
+}
+
+func TestTrustedIssuer(t *testing.T) {
+	token := func(claims string) string {
+		enc := base64.RawURLEncoding.EncodeToString
+		return enc([]byte(`{"alg":"RS256"}`)) + "." + enc([]byte(claims)) + "." + enc([]byte("signature"))
+	}
+	trusted := []string{"https://a.example.com", "https://b.example.com"}
+	if iss, err := TrustedIssuer(token(`{"iss":"https://b.example.com"}`), trusted); err != nil || iss != "https://b.example.com" {
+		t.Errorf("got %q, %v", iss, err)
+	}
+	for _, claims := range []string{`{"iss":"https://c.example.com"}`, `{"iss":"https://a.example.com/"}`, `{"sub":"a"}`} {
+		if _, err := TrustedIssuer(token(claims), trusted); !errors.Is(err, ErrUntrustedIssuer) {
+			t.Errorf("%s: got %v, want ErrUntrustedIssuer", claims, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package jwttools
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// ErrDecrypt is returned by Decrypt when none of the keys decrypt the token
+var ErrDecrypt = errors.New("unable to decrypt the token")
+
+// KeyAlgorithms are the JWE alg values that can be encrypted to, and the only ones Decrypt
+// accepts. RSA1_5 is left out, trying keys against it is a Bleichenbacher padding oracle, as are
+// the PBES2 algs, which let the token choose how much work decrypting it takes
+var KeyAlgorithms = []string{"RSA-OAEP", "RSA-OAEP-256", "ECDH-ES", "ECDH-ES+A128KW", "ECDH-ES+A192KW", "ECDH-ES+A256KW", "dir"}
+
+// ContentEncryptions are the JWE enc values the content can be encrypted with, and the size of
+// their key in bytes, which is the size of the secret for dir
+var ContentEncryptions = map[string]int{"A128GCM": 16, "A256GCM": 32, "A128CBC-HS256": 32}
+
+// Encrypter is the key a token is encrypted to
+type Encrypter struct {
+	KeyAlgorithm      string      // the JWE alg, one of KeyAlgorithms
+	ContentEncryption string      // the JWE enc, one of ContentEncryptions
+	Key               interface{} // the recipient's public key, or a []byte secret for dir
+	KeyID             string      // put in the kid header when it's set
+}
+
+// NewEncrypter encrypts to a public key loaded by the keys package, with RSA-OAEP-256 for RSA
+// keys and ECDH-ES for EC keys, and A256GCM
+func NewEncrypter(key *keys.Key) (Encrypter, error) {
+	e := Encrypter{ContentEncryption: "A256GCM", Key: key.Public, KeyID: key.KeyID}
+	switch key.Public.(type) {
+	case *rsa.PublicKey:
+		e.KeyAlgorithm = "RSA-OAEP-256"
+	case *ecdsa.PublicKey:
+		e.KeyAlgorithm = "ECDH-ES"
+	default:
+		return e, fmt.Errorf("a %T can't be encrypted to, only RSA and EC keys", key.Public)
+	}
+	return e, nil
+}
+
+// NewDirectEncrypter encrypts with a shared secret used directly as the content encryption key.
+// The enc is A128GCM for a 16 byte secret and A256GCM otherwise
+func NewDirectEncrypter(secret []byte, kid string) Encrypter {
+	e := Encrypter{KeyAlgorithm: "dir", ContentEncryption: "A256GCM", Key: secret, KeyID: kid}
+	if len(secret) == 16 {
+		e.ContentEncryption = "A128GCM"
+	}
+	return e
+}
+
+// check reports an alg, enc or key that can't be used together
+func (e Encrypter) check() error {
+	if !contains(KeyAlgorithms, e.KeyAlgorithm) {
+		return fmt.Errorf("unsupported JWE alg %q, must be one of %s", e.KeyAlgorithm, strings.Join(KeyAlgorithms, ", "))
+	}
+	size, ok := ContentEncryptions[e.ContentEncryption]
+	if !ok {
+		return fmt.Errorf("unsupported JWE enc %q, must be A128GCM, A256GCM or A128CBC-HS256", e.ContentEncryption)
+	}
+	switch key := e.Key.(type) {
+	case []byte:
+		if e.KeyAlgorithm != "dir" {
+			return fmt.Errorf("a secret can only be used with dir, not %s", e.KeyAlgorithm)
+		}
+		if len(key) != size {
+			return fmt.Errorf("%s needs a %d byte secret for dir, not %d bytes", e.ContentEncryption, size, len(key))
+		}
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(e.KeyAlgorithm, "RSA-") {
+			return fmt.Errorf("an RSA key can only be used with RSA-OAEP or RSA-OAEP-256, not %s", e.KeyAlgorithm)
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(e.KeyAlgorithm, "ECDH-ES") {
+			return fmt.Errorf("an EC key can only be used with ECDH-ES, not %s", e.KeyAlgorithm)
+		}
+	default:
+		return fmt.Errorf("a %T can't be encrypted to", e.Key)
+	}
+	return nil
+}
+
+// Encrypt encrypts the payload, returning the compact form. The contentType is put in the cty
+// header when it's set
+func (e Encrypter) Encrypt(payload []byte, contentType string) (string, error) {
+	if err := e.check(); err != nil {
+		return "", err
+	}
+	hdrs := jwe.NewHeaders()
+	if e.KeyID != "" {
+		hdrs.Set(jwe.KeyIDKey, e.KeyID)
+	}
+	if contentType != "" {
+		hdrs.Set(jwe.ContentTypeKey, contentType)
+	}
+	encrypted, err := jwe.Encrypt(payload,
+		jwe.WithKey(jwa.KeyEncryptionAlgorithm(e.KeyAlgorithm), e.Key),
+		jwe.WithContentEncryption(jwa.ContentEncryptionAlgorithm(e.ContentEncryption)),
+		jwe.WithProtectedHeaders(hdrs))
+	if err != nil {
+		return "", err
+	}
+	return string(encrypted), nil
+}
+
+// Nest encrypts a signed token, with a cty of JWT so that it's known to hold a JWT, RFC 7519
+// section 5.2
+func Nest(signed string, e Encrypter) (string, error) {
+	return e.Encrypt([]byte(signed), "JWT")
+}
+
+// MintEncrypted builds a token from the claims and options and encrypts it without signing it.
+// Only whoever holds the decryption key can read it, but anyone can make one unless dir is used
+func MintEncrypted(ctx context.Context, claims Claims, e Encrypter, opts MintOptions) (string, error) {
+	t, err := NewToken(claims, opts)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return e.Encrypt(payload, "")
+}
+
+// IsEncrypted reports whether a token is in the five part compact form of a JWE rather than the
+// three part form of a JWS or its JSON serialization
+func IsEncrypted(token string) bool {
+	return !strings.HasPrefix(token, "{") && strings.Count(token, ".") == 4
+}
+
+// Decrypt decrypts a JWE with the first of the keys that works, returning its protected header
+// and the payload. The keys are private keys, or []byte secrets for dir. A token with an alg that
+// isn't one of KeyAlgorithms or an enc that isn't one of ContentEncryptions is refused before any
+// key is tried
+func Decrypt(token string, keys []interface{}) (map[string]interface{}, []byte, error) {
+	msg, err := jwe.Parse([]byte(token))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	header, err := msg.ProtectedHeaders().AsMap(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return header, nil, fmt.Errorf("%w: the token is encrypted and there are no keys to decrypt it", ErrKeySource)
+	}
+	alg := msg.ProtectedHeaders().Algorithm()
+	if !contains(KeyAlgorithms, alg.String()) {
+		return header, nil, fmt.Errorf("%w: JWE alg %q, must be one of %s", ErrAlgNotAllowed, alg, strings.Join(KeyAlgorithms, ", "))
+	}
+	if enc := msg.ProtectedHeaders().ContentEncryption().String(); ContentEncryptions[enc] == 0 {
+		return header, nil, fmt.Errorf("%w: JWE enc %q, must be A128GCM, A256GCM or A128CBC-HS256", ErrAlgNotAllowed, enc)
+	}
+	var errs []string
+	for _, key := range keys {
+		payload, err := jwe.Decrypt([]byte(token), jwe.WithKey(alg, key))
+		if err == nil {
+			return header, payload, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return header, nil, fmt.Errorf("%w: %s", ErrDecrypt, strings.Join(errs, "; "))
+}
+
+func contains(list []string, value string) bool {
+	for _, member := range list {
+		if member == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package jwttools
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// A JWE with an alg or enc that isn't allowed is refused before any key is tried, RSA1_5 would
+// otherwise be a padding oracle
+func TestDecryptRefusesAlgs(t *testing.T) {
+	secret := make([]byte, 32)
+	for _, header := range []string{
+		`{"alg":"RSA1_5","enc":"A128CBC-HS256"}`,
+		`{"alg":"PBES2-HS256+A128KW","enc":"A128GCM","p2s":"c2FsdHNhbHQ","p2c":100000000}`,
+		`{"alg":"dir","enc":"A192GCM"}`,
+	} {
+		token := base64.RawURLEncoding.EncodeToString([]byte(header)) + ".AAAA.AAAA.AAAA.AAAA"
+		if _, _, err := Decrypt(token, []interface{}{secret}); !errors.Is(err, ErrAlgNotAllowed) {
+			t.Errorf("%s: got %v, want ErrAlgNotAllowed", header, err)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token, err := NewDirectEncrypter(secret, "k1").Encrypt([]byte("payload"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := make([]byte, 32)
+	header, payload, err := Decrypt(token, []interface{}{wrong, secret})
+	if err != nil || string(payload) != "payload" || header["kid"] != "k1" {
+		t.Errorf("got %v, %q, %v", header, payload, err)
+	}
+	if _, _, err := Decrypt(token, []interface{}{wrong}); err == nil {
+		t.Errorf("decrypted with the wrong secret")
+	}
+}
+
+// A token encrypted to an RSA or EC key decrypts with the private key, and a nested token has a
+// cty of JWT
+func TestEncryptToKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []crypto.Signer{rsaKey, ecKey} {
+		e, err := NewEncrypter(&keys.Key{Public: private.Public()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := Nest("a.b.c", e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(token) {
+			t.Errorf("%s: not a JWE", e.KeyAlgorithm)
+		}
+		header, payload, err := Decrypt(token, []interface{}{private})
+		if err != nil || string(payload) != "a.b.c" || header["cty"] != "JWT" {
+			t.Errorf("%s: got %v, %q, %v", e.KeyAlgorithm, header, payload, err)
+		}
+	}
+}
+
+func TestEncrypterCheck(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []Encrypter{
+		{KeyAlgorithm: "RSA1_5", ContentEncryption: "A256GCM", Key: rsaKey.Public()},
+		{KeyAlgorithm: "RSA-OAEP", ContentEncryption: "A192GCM", Key: rsaKey.Public()},
+		{KeyAlgorithm: "ECDH-ES", ContentEncryption: "A256GCM", Key: rsaKey.Public()},
+		{KeyAlgorithm: "RSA-OAEP", ContentEncryption: "A256GCM", Key: make([]byte, 32)},
+		{KeyAlgorithm: "dir", ContentEncryption: "A128GCM", Key: make([]byte, 32)},
+	} {
+		if _, err := e.Encrypt([]byte("payload"), ""); err == nil {
+			t.Errorf("encrypted with %s %s and a %T", e.KeyAlgorithm, e.ContentEncryption, e.Key)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package jwttools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Step is one of the steps Verify takes, given to Policy.Explain to say why a token was or wasn't
+// accepted
+type Step struct {
+	Name   string `json:"name"` // what was checked: decrypt, encoding, header, keys, candidates, signature or a claim
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"` // what was found, and for a claim what was expected
+}
+
+// explain gives the step to the policy's Explain when it has one
+func (p Policy) explain(name string, ok bool, format string, args ...interface{}) {
+	if p.Explain != nil {
+		p.Explain(Step{Name: name, OK: ok, Detail: fmt.Sprintf(format, args...)})
+	}
+}
+
+// summarize gives the alg and kid of a header
+func summarize(header jws.Headers) string {
+	if header.KeyID() == "" {
+		return fmt.Sprintf("alg %s, no kid", header.Algorithm())
+	}
+	return fmt.Sprintf("alg %s, kid %q", header.Algorithm(), header.KeyID())
+}
+
+// describeKeys lists the kid, kty and alg of each key
+func describeKeys(candidates []jwk.Key) string {
+	var list []string
+	for _, key := range candidates {
+		kid, alg := "none", "any"
+		if key.KeyID() != "" {
+			kid = fmt.Sprintf("%q", key.KeyID())
+		}
+		if key.Algorithm() != nil && key.Algorithm().String() != "" {
+			alg = key.Algorithm().String()
+		}
+		list = append(list, fmt.Sprintf("kid %s kty %s alg %s", kid, key.KeyType(), alg))
+	}
+	return strings.Join(list, "; ")
+}
+
+// claimCheck is one of the checks of the claims, what the claim has to be and the jwx validator
+// that checks it
+type claimCheck struct {
+	claim     string
+	expected  string
+	validator jwt.Validator
+}
+
+// claimChecks are the checks the policy makes of the claims, in the order they're made
+func (p Policy) claimChecks(now time.Time) []claimCheck {
+	// the times the leeway allows either side of now
+	earliest := now.Add(-p.AcceptableSkew).UTC().Format(time.RFC3339)
+	latest := now.Add(p.AcceptableSkew).UTC().Format(time.RFC3339)
+	checks := []claimCheck{
+		{"iat", "not after " + latest + ", if present", jwt.IsIssuedAtValid()},
+		{"exp", "after " + earliest + ", if present", jwt.IsExpirationValid()},
+		{"nbf", "not after " + latest + ", if present", jwt.IsNbfValid()},
+	}
+	if p.Issuer != "" {
+		checks = append(checks, claimCheck{"iss", fmt.Sprintf("%q", p.Issuer), jwt.ClaimValueIs(jwt.IssuerKey, p.Issuer)})
+	}
+	if p.Audience != "" {
+		checks = append(checks, claimCheck{"aud", fmt.Sprintf("contains %q", p.Audience), jwt.ClaimContainsString(jwt.AudienceKey, p.Audience)})
+	}
+	if len(p.Audiences) > 0 {
+		checks = append(checks, claimCheck{"aud", "contains one of " + strings.Join(p.Audiences, ", "), audienceIn(p.Audiences)})
+	}
+	for _, name := range p.RequiredClaims {
+		checks = append(checks, claimCheck{name, "present", jwt.IsRequired(name)})
+	}
+	return checks
+}
+
+// ClaimError is a claim check that failed
+type ClaimError struct {
+	Claim string
+	Err   error
+}
+
+func (e *ClaimError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClaimError) Unwrap() error {
+	return e.Err
+}
+
+// validateClaims makes each of the policy's checks of the claims, returning the first that fails.
+// With Explain set every check is made and explained, not just the ones up to the first failure
+func (p Policy) validateClaims(ctx context.Context, t jwt.Token) error {
+	now := time.Now()
+	ctx = jwt.SetValidationCtxSkew(ctx, p.AcceptableSkew)
+	ctx = jwt.SetValidationCtxClock(ctx, jwt.ClockFunc(func() time.Time { return now }))
+	ctx = jwt.SetValidationCtxTruncation(ctx, time.Second)
+	var firstErr error
+	for _, check := range p.claimChecks(now) {
+		err := check.validator.Validate(ctx, t)
+		if p.Explain != nil {
+			actual := "absent"
+			if value, ok := t.Get(check.claim); ok {
+				actual = formatClaim(value)
+			}
+			if err != nil {
+				p.explain(check.claim, false, "%s, expected %s: %v", actual, check.expected, err)
+			} else {
+				p.explain(check.claim, true, "%s, expected %s", actual, check.expected)
+			}
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = &ClaimError{Claim: check.claim, Err: err}
+			}
+			if p.Explain == nil {
+				break
+			}
+		}
+	}
+	return firstErr
+}
+
+// formatClaim writes a claim's value for an explanation, times in RFC 3339
+func formatClaim(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,104 @@
+package jwttools
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/cert"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// JWKSOptions control how keys are turned into JWKs
+type JWKSOptions struct {
+	Private bool // include the private keys, keys without one are left out
+}
+
+// BuildJWK turns a key into a JWK. A key with a certificate has the serial number as the kid and
+// the chain in the x5c. Bare keys have no serial number so their RFC 7638 thumbprint is the kid
+func BuildJWK(key *keys.Key, opts JWKSOptions) (jwk.Key, error) {
+	// the alg comes from the key rather than from the algorithm the certificate's issuer signed
+	// it with, which can be anything for a certificate signed by a CA
+	alg := key.SigningAlgorithm()
+	if alg == "" {
+		return nil, fmt.Errorf("%w %T", keys.ErrUnsupportedKey, key.Public)
+	}
+	var raw interface{} = key.Public
+	if opts.Private {
+		if key.Private == nil {
+			return nil, keys.ErrNoPrivateKey
+		}
+		raw = key.Private
+	}
+	j, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	use := key.Use
+	if use == "" {
+		use = "sig"
+	}
+	j.Set(jwk.KeyUsageKey, use)
+	j.Set(jwk.AlgorithmKey, jwa.SignatureAlgorithm(alg))
+
+	if len(key.Certificates) > 0 {
+		j.Set(jwk.X509CertChainKey, x5c(key.Certificates))
+		j.Set(jwk.X509CertThumbprintKey, x5t(key.Certificates[0]))
+		j.Set(jwk.X509CertThumbprintS256Key, x5tS256(key.Certificates[0]))
+	}
+
+	kid := key.KeyID
+	if kid == "" {
+		thumbprint, err := j.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		kid = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+	j.Set(jwk.KeyIDKey, kid)
+	return j, nil
+}
+
+// BuildJWKS turns the keys into a JWKS, stopping at the first one that can't be used
+func BuildJWKS(keyList []*keys.Key, opts JWKSOptions) (jwk.Set, error) {
+	set := jwk.NewSet()
+	for _, key := range keyList {
+		j, err := BuildJWK(key, opts)
+		if err != nil {
+			return nil, fmt.Errorf("kid %q: %w", key.KeyID, err)
+		}
+		set.AddKey(j)
+	}
+	return set, nil
+}
+
+// x5c is the certificate chain as it goes in an x5c
+func x5c(certs []*x509.Certificate) *cert.Chain {
+	var chain cert.Chain
+	for _, c := range certs {
+		chain.AddString(base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	return &chain
+}
+
+// x5t is the SHA-1 thumbprint of the certificate
+func x5t(c *x509.Certificate) string {
+	sum := sha1.Sum(c.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// x5tS256 is the SHA-256 thumbprint of the certificate
+func x5tS256(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Certificates decodes the x5c of a JWK, the leaf certificate first
+func Certificates(key jwk.Key) ([]*x509.Certificate, error) {
+	return decodeChain(key.X509CertChain())
+}
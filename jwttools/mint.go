@@ -0,0 +1,282 @@
+// Package jwttools is the minting, JWKS building and verification logic behind the jwt-tools
+// commands, so that it can be called from Go code rather than by running the commands.
+//
+// It is built on github.com/lestrrat-go/jwx/v2 and keys are loaded with the keys package, so a
+// token minted here verifies with Verify without any library differences in between.
+package jwttools
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// The special values of MintOptions.JTI
+const (
+	JTIAuto = "auto" // a random UUID, the default
+	JTINone = "none" // no jti claim
+)
+
+// Claims are the claims to put in a token, as read from a claims file
+type Claims map[string]interface{}
+
+// Signer is the key a token is signed with
+type Signer struct {
+	Algorithm    string              // the JWS alg, e.g. RS256 or HS256
+	Key          interface{}         // a crypto.Signer, or a []byte secret for HMAC
+	KeyID        string              // put in the kid header when it's set
+	Certificates []*x509.Certificate // the certificate for the key first, then its chain
+}
+
+// NewSigner signs with a private key loaded by the keys package
+func NewSigner(key *keys.Key) Signer {
+	return Signer{
+		Algorithm:    key.SigningAlgorithm(),
+		Key:          key.Private,
+		KeyID:        key.KeyID,
+		Certificates: key.Certificates,
+	}
+}
+
+// NewHMACSigner signs with HS256 and a shared secret
+func NewHMACSigner(secret []byte) Signer {
+	return Signer{
+		Algorithm: jwa.HS256.String(),
+		Key:       secret,
+	}
+}
+
+// MintOptions are the claims and headers that are set around the Claims. The iat and jti are
+// defaults that the Claims override, everything else overrides the Claims
+type MintOptions struct {
+	IssuedAtOffset time.Duration          // added to the current time for the iat
+	Expiry         time.Duration          // the exp is this long after the current time, none if 0
+	NotBefore      *time.Duration         // the nbf is this long after the current time, none if nil
+	Issuer         string                 // set the iss
+	Audience       []string               // set the aud
+	JTI            string                 // JTIAuto, JTINone or the jti to use, JTIAuto if ""
+	RandomSubject  bool                   // set a random sub
+	Subject        string                 // set the sub, overrides RandomSubject
+	Policy         string                 // set the pol
+	NoDefaults     bool                   // only the claims and options given, no iat unless IssuedAtOffset isn't 0 and no jti unless JTI is set
+	X5C            bool                   // put the Signer's certificate chain in the x5c header
+	X5T            bool                   // put the SHA-1 thumbprint of the Signer's certificate in the x5t header
+	X5TS256        bool                   // put the SHA-256 thumbprint of the Signer's certificate in the x5t#S256 header
+	Headers        map[string]interface{} // extra protected headers, these override all the others
+	Now            func() time.Time       // the current time, time.Now if nil
+}
+
+// NewToken builds the unsigned token from the claims and options
+func NewToken(claims Claims, opts MintOptions) (jwt.Token, error) {
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+
+	t := jwt.New()
+	if !opts.NoDefaults || opts.IssuedAtOffset != 0 {
+		t.Set(jwt.IssuedAtKey, now.Add(opts.IssuedAtOffset).Unix())
+	}
+	if !opts.NoDefaults && opts.JTI == "" {
+		t.Set(jwt.JwtIDKey, uuid.New().String())
+	}
+
+	for key, value := range claims {
+		if err := t.Set(key, value); err != nil {
+			return nil, fmt.Errorf("claim %s: %w", key, err)
+		}
+	}
+
+	// options override the claims
+	switch opts.JTI {
+	case "":
+	case JTIAuto:
+		t.Set(jwt.JwtIDKey, uuid.New().String())
+	case JTINone:
+		t.Remove(jwt.JwtIDKey)
+	default:
+		t.Set(jwt.JwtIDKey, opts.JTI)
+	}
+	if opts.Expiry != 0 {
+		t.Set(jwt.ExpirationKey, now.Add(opts.Expiry).Unix())
+	}
+	if opts.NotBefore != nil {
+		t.Set(jwt.NotBeforeKey, now.Add(*opts.NotBefore).Unix())
+	}
+	if opts.Issuer != "" {
+		t.Set(jwt.IssuerKey, opts.Issuer)
+	}
+	if len(opts.Audience) > 0 {
+		t.Set(jwt.AudienceKey, opts.Audience)
+	}
+	if opts.RandomSubject {
+		t.Set(jwt.SubjectKey, uuid.New().String())
+	}
+	if opts.Policy != "" {
+		t.Set("pol", opts.Policy)
+	}
+	// Subject overrides both the claims and RandomSubject
+	if opts.Subject != "" {
+		t.Set(jwt.SubjectKey, opts.Subject)
+	}
+	return t, nil
+}
+
+// headers are the kid and the certificate headers the options ask for
+func (signer Signer) headers(opts MintOptions) (jws.Headers, error) {
+	hdrs := jws.NewHeaders()
+	if signer.KeyID != "" {
+		hdrs.Set(jws.KeyIDKey, signer.KeyID)
+	}
+	if !opts.X5C && !opts.X5T && !opts.X5TS256 {
+		return hdrs, nil
+	}
+	if len(signer.Certificates) == 0 {
+		return nil, errors.New("the x5c, x5t and x5t#S256 headers need a certificate for the key")
+	}
+	if opts.X5C {
+		hdrs.Set(jws.X509CertChainKey, x5c(signer.Certificates))
+	}
+	if opts.X5T {
+		hdrs.Set(jws.X509CertThumbprintKey, x5t(signer.Certificates[0]))
+	}
+	if opts.X5TS256 {
+		hdrs.Set(jws.X509CertThumbprintS256Key, x5tS256(signer.Certificates[0]))
+	}
+	return hdrs, nil
+}
+
+// setHeader sets a header from a value decoded from JSON. jwx wants its own types for the headers
+// it knows about, such as []string for crit, so when the value isn't one of them it is decoded
+// from JSON the way jwx would when parsing a token
+func setHeader(hdrs jws.Headers, key string, value interface{}) error {
+	if err := hdrs.Set(key, value); err == nil {
+		return nil
+	}
+	data, err := json.Marshal(map[string]interface{}{key: value})
+	if err != nil {
+		return err
+	}
+	decoded := jws.NewHeaders()
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return err
+	}
+	typed, _ := decoded.Get(key)
+	return hdrs.Set(key, typed)
+}
+
+// protectedHeaders are the headers for the signer with the options' Headers over them
+func (signer Signer) protectedHeaders(opts MintOptions) (jws.Headers, error) {
+	hdrs, err := signer.headers(opts)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range opts.Headers {
+		if err := setHeader(hdrs, key, value); err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+	}
+	return hdrs, nil
+}
+
+// Mint builds a token from the claims and options and signs it, returning the compact form
+func Mint(ctx context.Context, claims Claims, signer Signer, opts MintOptions) (string, error) {
+	t, err := NewToken(claims, opts)
+	if err != nil {
+		return "", err
+	}
+
+	hdrs, err := signer.protectedHeaders(opts)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := jwt.Sign(t, jwt.WithKey(jwa.SignatureAlgorithm(signer.Algorithm), signer.Key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// The JWS serializations, RFC 7515 section 7. Mint gives the compact form and MintJSON the others
+const (
+	FormatCompact   = "compact"
+	FormatFlattened = "flattened"
+	FormatGeneral   = "general"
+)
+
+// MintJSON builds a token from the claims and options and signs it with every signer, each
+// signature with its own protected header, returning the JSON serialization. Only the general
+// form can have more than one signature, signing with several keys at once is how a key rotation
+// overlap looks to a verifier
+func MintJSON(ctx context.Context, claims Claims, signers []Signer, opts MintOptions, format string) (string, error) {
+	switch {
+	case format != FormatFlattened && format != FormatGeneral:
+		return "", fmt.Errorf("unknown JSON serialization %q, must be flattened or general", format)
+	case len(signers) == 0:
+		return "", errors.New("no signers")
+	case len(signers) > 1 && format == FormatFlattened:
+		return "", errors.New("the flattened serialization can only have one signature, use general")
+	}
+	t, err := NewToken(claims, opts)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+
+	options := []jws.SignOption{jws.WithJSON()}
+	for _, signer := range signers {
+		hdrs, err := signer.protectedHeaders(opts)
+		if err != nil {
+			return "", err
+		}
+		// jwt.Sign adds this for a compact token
+		if hdrs.Type() == "" {
+			hdrs.Set(jws.TypeKey, "JWT")
+		}
+		options = append(options, jws.WithKey(jwa.SignatureAlgorithm(signer.Algorithm), signer.Key, jws.WithProtectedHeaders(hdrs)))
+	}
+	signed, err := jws.Sign(payload, options...)
+	if err != nil {
+		return "", err
+	}
+	if format == FormatGeneral && len(signers) == 1 {
+		// jwx flattens a message with one signature
+		return generalJSON(signed)
+	}
+	return string(signed), nil
+}
+
+// generalJSON turns the flattened serialization into the general one
+func generalJSON(flattened []byte) (string, error) {
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(flattened, &message); err != nil {
+		return "", err
+	}
+	signature := map[string]json.RawMessage{}
+	for _, name := range []string{"protected", "header", "signature"} {
+		if value, ok := message[name]; ok {
+			signature[name] = value
+			delete(message, name)
+		}
+	}
+	signatures, err := json.Marshal([]map[string]json.RawMessage{signature})
+	if err != nil {
+		return "", err
+	}
+	message["signatures"] = signatures
+	general, err := json.Marshal(message)
+	return string(general), err
+}
@@ -0,0 +1,196 @@
+package jwttools
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// testSigners is a private key of every type the tools sign with, loaded the way the commands
+// load them
+func testSigners(t *testing.T) map[string]*keys.Key {
+	t.Helper()
+	generated := map[string]func() (crypto.Signer, error){
+		"RS256": func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+		"ES256": func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+		"ES384": func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) },
+		"ES512": func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P521(), rand.Reader) },
+		"EdDSA": func() (crypto.Signer, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		},
+	}
+	dir := t.TempDir()
+	found := map[string]*keys.Key{}
+	for alg, generate := range generated {
+		raw, err := generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(dir, alg+".pem")
+		if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		key, err := keys.LoadPrivateKey(filename, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[alg] = key
+	}
+	return found
+}
+
+// A token minted with each type of key verifies with its public key, both loaded directly and
+// from a JWKS built from it, and doesn't verify with a key of another type
+func TestMintVerify(t *testing.T) {
+	ctx := context.Background()
+	signers := testSigners(t)
+	for alg, key := range signers {
+		t.Run(alg, func(t *testing.T) {
+			signer := NewSigner(key)
+			if signer.Algorithm != alg {
+				t.Fatalf("signing with %s, want %s", signer.Algorithm, alg)
+			}
+			token, err := Mint(ctx, Claims{"scope": "read"}, signer, MintOptions{Expiry: time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := Verify(ctx, token, StaticKeys{key}, Policy{})
+			if err != nil {
+				t.Fatalf("with the key: %v", err)
+			}
+			if fmt.Sprint(result.Header["alg"]) != alg || result.Claims["scope"] != "read" {
+				t.Errorf("got %v %v", result.Header, result.Claims)
+			}
+
+			set, err := BuildJWKS([]*keys.Key{key}, JWKSOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(set)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filename := filepath.Join(t.TempDir(), "jwks.json")
+			if err := os.WriteFile(filename, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Verify(ctx, token, JWKSFile(filename), Policy{}); err != nil {
+				t.Errorf("with the JWKS: %v", err)
+			}
+
+			for other, otherKey := range signers {
+				if other == alg {
+					continue
+				}
+				if _, err := Verify(ctx, token, StaticKeys{otherKey}, Policy{}); err == nil {
+					t.Errorf("verified with the %s key", other)
+				}
+			}
+		})
+	}
+}
+
+func TestMintVerifyHMAC(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("a secret that is long enough for HS256")
+	token, err := Mint(ctx, Claims{"scope": "read"}, NewHMACSigner(secret), MintOptions{Expiry: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Verify(ctx, token, HMACSecret(secret), Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Header["alg"]) != "HS256" || result.Claims["scope"] != "read" {
+		t.Errorf("got %v %v", result.Header, result.Claims)
+	}
+	if _, err := Verify(ctx, token, HMACSecret("another secret that is long enough too"), Policy{}); err == nil {
+		t.Errorf("verified with another secret")
+	}
+}
+
+// Each signature of a JSON serialized token verifies on its own, so a verifier with either key
+// of a rotation accepts it
+func TestMintJSON(t *testing.T) {
+	ctx := context.Background()
+	signers := testSigners(t)
+	rsaKey, ecKey := signers["RS256"], signers["ES256"]
+	tests := []struct {
+		format  string
+		signers []*keys.Key
+	}{
+		{FormatFlattened, []*keys.Key{rsaKey}},
+		{FormatGeneral, []*keys.Key{rsaKey}},
+		{FormatGeneral, []*keys.Key{rsaKey, ecKey}},
+	}
+	for _, test := range tests {
+		var list []Signer
+		for _, key := range test.signers {
+			list = append(list, NewSigner(key))
+		}
+		token, err := MintJSON(ctx, Claims{"sub": "alice"}, list, MintOptions{Expiry: time.Minute}, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var message map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(token), &message); err != nil {
+			t.Fatalf("%s: %v", token, err)
+		}
+		if test.format == FormatFlattened {
+			if message["protected"] == nil || message["signature"] == nil || message["signatures"] != nil {
+				t.Errorf("not flattened: %s", token)
+			}
+		} else {
+			var signatures []map[string]interface{}
+			if err := json.Unmarshal(message["signatures"], &signatures); err != nil || len(signatures) != len(test.signers) || message["signature"] != nil {
+				t.Errorf("not general with %d signatures: %s", len(test.signers), token)
+			}
+		}
+		for _, key := range test.signers {
+			result, err := Verify(ctx, token, StaticKeys{key}, Policy{})
+			if err != nil {
+				t.Errorf("%s with %d signers doesn't verify with the %s key: %v", test.format, len(test.signers), key.SigningAlgorithm(), err)
+				continue
+			}
+			if result.Claims["sub"] != "alice" {
+				t.Errorf("got claims %v", result.Claims)
+			}
+		}
+	}
+}
+
+func TestMintJSONErrors(t *testing.T) {
+	ctx := context.Background()
+	signer := NewHMACSigner([]byte("a secret that is long enough for HS256"))
+	for _, test := range []struct {
+		format  string
+		signers []Signer
+	}{
+		{FormatCompact, []Signer{signer}},
+		{FormatGeneral, nil},
+		{FormatFlattened, []Signer{signer, signer}},
+	} {
+		if _, err := MintJSON(ctx, Claims{}, test.signers, MintOptions{}, test.format); err == nil {
+			t.Errorf("minted %s with %d signers", test.format, len(test.signers))
+		}
+	}
+}
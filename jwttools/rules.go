@@ -0,0 +1,372 @@
+package jwttools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPolicy is what a PolicyError wraps
+var ErrPolicy = errors.New("the token doesn't satisfy the policy")
+
+// RuleOperators are the operators a Rule can use
+var RuleOperators = []string{"==", "!=", "contains", "includes", "in", "matches", "exists", "<", "<=", ">", ">="}
+
+// Rule is a check of one claim, written as the claim, an operator and a value:
+//
+//	scope contains orders:write
+//	tenant in [a, b]
+//	pol matches ^[0-9a-f]{24}$
+//	not amr includes pwd
+//
+// The claim can be quoted, "https://example.com/roles", and a dotted path into a claim that's an
+// object, realm_access.roles, when there isn't a claim with the dots in its name. The value is a
+// YAML scalar, or for in a YAML flow sequence. contains and includes are the same, true when an
+// array has the value in it or a space separated string such as scope has it as one of its words.
+// matches takes a regular expression as it's written, anchor it with ^ and $ to match the whole
+// value. in and matches are true for an array when any of its members is. A claim that isn't there
+// fails every rule but not ... exists, not included
+type Rule struct {
+	Text     string
+	claim    string
+	negate   bool
+	operator string
+	value    interface{}
+	re       *regexp.Regexp
+}
+
+// RuleResult is whether a claim satisfied a rule, with the claim's value
+type RuleResult struct {
+	Rule   string `json:"rule"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// PolicyError is returned by Verify when the claims don't satisfy every rule, with the result of
+// each of them
+type PolicyError struct {
+	Results []RuleResult
+}
+
+func (e *PolicyError) Error() string {
+	var failed []string
+	for _, result := range e.Results {
+		if !result.OK {
+			failed = append(failed, fmt.Sprintf("%s (%s)", result.Rule, result.Detail))
+		}
+	}
+	return fmt.Sprintf("%v: %s", ErrPolicy, strings.Join(failed, "; "))
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrPolicy
+}
+
+// nextWord splits a word, or a double or single quoted string, off the front of the text
+func nextWord(text string) (string, string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", "", nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quote in %q", text)
+		}
+		return text[1 : end+1], text[end+2:], nil
+	}
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		return text[:i], text[i:], nil
+	}
+	return text, "", nil
+}
+
+// ParseRule reads a rule
+func ParseRule(text string) (*Rule, error) {
+	r := &Rule{Text: strings.TrimSpace(text)}
+	rest := r.Text
+	if strings.HasPrefix(rest, "not ") {
+		r.negate = true
+		rest = rest[4:]
+	}
+	var err error
+	if r.claim, rest, err = nextWord(rest); err != nil {
+		return nil, err
+	}
+	r.operator, rest, _ = nextWord(rest)
+	rest = strings.TrimSpace(rest)
+	switch {
+	case r.claim == "" || r.operator == "":
+		return nil, fmt.Errorf("the rule %q isn't a claim, an operator and a value", r.Text)
+	case !contains(RuleOperators, r.operator):
+		return nil, fmt.Errorf("the rule %q has an unknown operator %q, must be one of %s", r.Text, r.operator, strings.Join(RuleOperators, ", "))
+	case r.operator == "exists":
+		if rest != "" {
+			return nil, fmt.Errorf("the rule %q has a value, exists doesn't take one", r.Text)
+		}
+		return r, nil
+	case rest == "":
+		return nil, fmt.Errorf("the rule %q has no value", r.Text)
+	}
+	if r.operator == "matches" {
+		// a regular expression is taken as it's written, [a-z]+ isn't a YAML list
+		if rest[0] == '"' || rest[0] == '\'' {
+			if rest, _, err = nextWord(rest); err != nil {
+				return nil, err
+			}
+		}
+		if r.re, err = regexp.Compile(rest); err != nil {
+			return nil, fmt.Errorf("the rule %q: %w", r.Text, err)
+		}
+		return r, nil
+	}
+	if err := yaml.Unmarshal([]byte(rest), &r.value); err != nil {
+		return nil, fmt.Errorf("the rule %q: %w", r.Text, err)
+	}
+	switch value := r.value.(type) {
+	case nil:
+		return nil, fmt.Errorf("the rule %q has a value that reads as null, a # starts a YAML comment, quote it", r.Text)
+	case []interface{}:
+		if r.operator != "in" {
+			return nil, fmt.Errorf("the rule %q has a list, only in takes one", r.Text)
+		}
+		for _, member := range value {
+			if member == nil {
+				return nil, fmt.Errorf("the rule %q has a list member that reads as null, quote it", r.Text)
+			}
+		}
+	case map[string]interface{}:
+		return nil, fmt.Errorf("the rule %q has a value that reads as an object, quote it", r.Text)
+	default:
+		if r.operator == "in" {
+			return nil, fmt.Errorf("the rule %q needs a list such as [a, b] for in", r.Text)
+		}
+	}
+	return r, nil
+}
+
+// ParseRules reads a rule from each of the lines, skipping blank lines and # comments
+func ParseRules(lines []string) ([]*Rule, error) {
+	var rules []*Rule
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// LoadRules reads a file of rules, one to a line
+func LoadRules(filename string) ([]*Rule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	rules, err := ParseRules(lines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// Lookup finds the claim, or follows the dotted path into the claims when there's no claim with
+// that name
+func Lookup(claims map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := claims[path]; ok {
+		return value, true
+	}
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// members is the members of an array claim, nil for anything else
+func members(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list
+	}
+	return nil
+}
+
+// number is the value as a number, times as seconds since the epoch
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case time.Time:
+		return float64(v.Unix()), true
+	}
+	return 0, false
+}
+
+// normalize makes a claim or a value from a rule comparable with reflect.DeepEqual, with numbers
+// and times as float64 and arrays as []interface{} all the way down
+func normalize(value interface{}) interface{} {
+	if n, ok := number(value); ok {
+		return n
+	}
+	if list := members(value); list != nil {
+		normalized := make([]interface{}, len(list))
+		for i, member := range list {
+			normalized[i] = normalize(member)
+		}
+		return normalized
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		normalized := make(map[string]interface{}, len(object))
+		for name, member := range object {
+			normalized[name] = normalize(member)
+		}
+		return normalized
+	}
+	return value
+}
+
+// equal compares a claim with a value from a rule. Arrays and objects are compared member by
+// member, and only equal another of the same shape. Numbers are compared as numbers and other
+// scalars as they're written, so that tenant == 123 is true for a tenant of "123"
+func equal(a, b interface{}) bool {
+	a, b = normalize(a), normalize(b)
+	if composite(a) || composite(b) {
+		return reflect.DeepEqual(a, b)
+	}
+	x, aok := a.(float64)
+	y, bok := b.(float64)
+	if aok && bok {
+		return x == y
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func composite(value interface{}) bool {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// Evaluate checks the claims against the rule
+func (r *Rule) Evaluate(claims map[string]interface{}) RuleResult {
+	value, found := Lookup(claims, r.claim)
+	ok := false
+	switch {
+	case r.operator == "exists":
+		ok = found
+	case !found:
+	case r.operator == "==":
+		ok = equal(value, r.value)
+	case r.operator == "!=":
+		ok = !equal(value, r.value)
+	case r.operator == "contains" || r.operator == "includes":
+		if s, isString := value.(string); isString {
+			ok = contains(strings.Fields(s), fmt.Sprint(r.value))
+		}
+		for _, member := range members(value) {
+			ok = ok || equal(member, r.value)
+		}
+	case r.operator == "in":
+		list := members(value)
+		if list == nil {
+			list = []interface{}{value}
+		}
+		for _, member := range list {
+			for _, allowed := range r.value.([]interface{}) {
+				ok = ok || equal(member, allowed)
+			}
+		}
+	case r.operator == "matches":
+		list := members(value)
+		if list == nil {
+			list = []interface{}{value}
+		}
+		for _, member := range list {
+			ok = ok || r.re.MatchString(fmt.Sprint(member))
+		}
+	default:
+		x, xok := number(value)
+		y, yok := number(r.value)
+		if xok && yok {
+			switch r.operator {
+			case "<":
+				ok = x < y
+			case "<=":
+				ok = x <= y
+			case ">":
+				ok = x > y
+			case ">=":
+				ok = x >= y
+			}
+		}
+	}
+	if r.negate {
+		ok = !ok
+	}
+	if !found && r.operator != "exists" {
+		// not doesn't turn a missing claim into a pass, only not ... exists asks for one
+		ok = false
+	}
+	detail := "absent"
+	if found {
+		detail = formatClaim(value)
+	}
+	return RuleResult{Rule: r.Text, OK: ok, Detail: detail}
+}
+
+// evaluateRules checks the claims against every one of the policy's rules, failing with a
+// PolicyError if any of them aren't satisfied
+func (p Policy) evaluateRules(claims map[string]interface{}) ([]RuleResult, error) {
+	var results []RuleResult
+	failed := false
+	for _, r := range p.Rules {
+		result := r.Evaluate(claims)
+		p.explain("rule", result.OK, "%s: %s", result.Rule, result.Detail)
+		results = append(results, result)
+		failed = failed || !result.OK
+	}
+	if failed {
+		return results, &PolicyError{Results: results}
+	}
+	return results, nil
+}
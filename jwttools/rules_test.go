@@ -0,0 +1,128 @@
+package jwttools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, text := range []string{
+		"scope",
+		"scope between a",
+		"scope exists orders",
+		"scope ==",
+		"roles == [a, b]",
+		"tenant in a",
+		"tenant in {a: b}",
+		"sub matches (",
+		`"unterminated contains a`,
+		"roles contains #admin",
+		"sub == ~",
+		"sub != null",
+		"tenant in [a, ~]",
+	} {
+		if _, err := ParseRule(text); err == nil {
+			t.Errorf("ParseRule(%q) didn't fail", text)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]string{"", "# a comment", "  sub exists  ", "scope contains a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Text != "sub exists" {
+		t.Errorf("got %d rules, want the 2 that aren't blank or comments", len(rules))
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":                       "alice",
+		"scope":                     "orders:read orders:write",
+		"roles":                     []interface{}{"admin", "dev"},
+		"groups":                    []string{"eng"},
+		"tenant":                    "123",
+		"level":                     float64(30),
+		"exp":                       time.Unix(2000000000, 0),
+		"realm_access":              map[string]interface{}{"roles": []interface{}{"viewer"}},
+		"https://example.com/roles": []interface{}{"owner"},
+		"a.b":                       "dotted",
+		"tags":                      []interface{}{"#admin"},
+		"pairs":                     []interface{}{[]interface{}{"a", float64(1)}},
+	}
+	tests := []struct {
+		rule string
+		ok   bool
+	}{
+		{"sub == alice", true},
+		{"sub != alice", false},
+		{"scope contains orders:write", true},
+		{"scope contains orders", false},
+		{"scope includes orders:read", true},
+		{"roles contains admin", true},
+		{"roles contains root", false},
+		{"groups contains eng", true},
+		{"not roles includes root", true},
+		{"tenant == 123", true},
+		{"tenant in [a, 123]", true},
+		{"tenant in [a, b]", false},
+		{"roles in [dev, ops]", true},
+		{"sub matches ^a", true},
+		{"sub matches '^[0-9]+$'", false},
+		{"roles matches ^ad", true},
+		{"level >= 30", true},
+		{"level > 30", false},
+		{"level < 31", true},
+		{"level <= 29", false},
+		{"sub < 31", false},
+		{"exp > 1999999999", true},
+		{"realm_access.roles contains viewer", true},
+		{`"https://example.com/roles" contains owner`, true},
+		{"a.b == dotted", true},
+		{"sub exists", true},
+		{"missing exists", false},
+		{"not missing exists", true},
+		{"missing != x", false},
+		{"realm_access.missing.roles contains x", false},
+		{"not missing includes x", false},
+		{"not missing == x", false},
+		{"not missing matches x", false},
+		{`tags contains "#admin"`, true},
+		{`roles == "[admin dev]"`, false},
+		{`roles != "[admin dev]"`, true},
+		{`realm_access == "map[roles:[viewer]]"`, false},
+		{"pairs in [[a, 1]]", true},
+		{"pairs in [[a, 2], [a]]", false},
+		{`pairs in ["[a 1]"]`, false},
+	}
+	for _, test := range tests {
+		r, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", test.rule, err)
+			continue
+		}
+		result := r.Evaluate(claims)
+		if result.OK != test.ok {
+			t.Errorf("%s = %v with %s, want %v", test.rule, result.OK, result.Detail, test.ok)
+		}
+		if result.Rule != test.rule {
+			t.Errorf("the result of %q is for %q", test.rule, result.Rule)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	rules, err := ParseRules([]string{"sub == alice", "scope contains write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Policy{Rules: rules}.evaluateRules(map[string]interface{}{"sub": "alice", "scope": "read"})
+	if len(results) != 2 || !results[0].OK || results[1].OK {
+		t.Errorf("got %+v, want the first rule satisfied and the second not", results)
+	}
+	if code, kind := ErrorCode(err); code != "policy_failed" || kind != KindClaims {
+		t.Errorf("got %s %s for %v, want policy_failed claims", code, kind, err)
+	}
+}
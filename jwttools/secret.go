@@ -0,0 +1,61 @@
+package jwttools
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// ErrShortSecret is returned by CheckSecret for an HMAC secret shorter than the hash
+var ErrShortSecret = errors.New("the HMAC secret is shorter than the hash")
+
+// SecretLength is the shortest secret that can be used with an HMAC alg, the size of its hash,
+// RFC 7518 section 3.2. It is 0 for algs that aren't HMAC
+func SecretLength(alg string) int {
+	switch jwa.SignatureAlgorithm(alg) {
+	case jwa.HS256:
+		return 32
+	case jwa.HS384:
+		return 48
+	case jwa.HS512:
+		return 64
+	}
+	return 0
+}
+
+// CheckSecret reports a secret that is too short for the alg
+func CheckSecret(alg string, secret []byte) error {
+	if len(secret) < SecretLength(alg) {
+		return fmt.Errorf("%w: %s needs at least %d bytes, not %d", ErrShortSecret, alg, SecretLength(alg), len(secret))
+	}
+	return nil
+}
+
+// GenerateSecret makes a random secret the right length for the HMAC alg as an oct JWK, with the
+// alg and its thumbprint as the kid
+func GenerateSecret(alg string) (jwk.Key, error) {
+	length := SecretLength(alg)
+	if length == 0 {
+		return nil, fmt.Errorf("%s is not an HMAC alg", alg)
+	}
+	secret := make([]byte, length)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key, err := jwk.FromRaw(secret)
+	if err != nil {
+		return nil, err
+	}
+	key.Set(jwk.AlgorithmKey, jwa.SignatureAlgorithm(alg))
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	key.Set(jwk.KeyIDKey, base64.RawURLEncoding.EncodeToString(thumbprint))
+	return key, nil
+}
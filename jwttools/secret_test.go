@@ -0,0 +1,53 @@
+package jwttools
+
+import (
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+func TestCheckSecret(t *testing.T) {
+	tests := []struct {
+		alg    string
+		length int
+		short  bool
+	}{
+		{"HS256", 32, false},
+		{"HS256", 31, true},
+		{"HS384", 47, true},
+		{"HS384", 48, false},
+		{"HS512", 63, true},
+		{"HS512", 64, false},
+		{"RS256", 0, false},
+	}
+	for _, test := range tests {
+		err := CheckSecret(test.alg, make([]byte, test.length))
+		if errors.Is(err, ErrShortSecret) != test.short || (err != nil) != test.short {
+			t.Errorf("%s with %d bytes: got %v", test.alg, test.length, err)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	for _, alg := range []string{"HS256", "HS384", "HS512"} {
+		key, err := GenerateSecret(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		symmetric, ok := key.(jwk.SymmetricKey)
+		if !ok || len(symmetric.Octets()) != SecretLength(alg) || key.Algorithm().String() != alg {
+			t.Errorf("%s: got %v", alg, key)
+			continue
+		}
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil || key.KeyID() != base64.RawURLEncoding.EncodeToString(thumbprint) {
+			t.Errorf("%s: the kid %s isn't the thumbprint", alg, key.KeyID())
+		}
+	}
+	if _, err := GenerateSecret("RS256"); err == nil {
+		t.Errorf("generated a secret for RS256")
+	}
+}
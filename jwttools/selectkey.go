@@ -0,0 +1,183 @@
+package jwttools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// The ways SelectKeys can fail to find a key for a token
+var (
+	ErrKidNotFound     = errors.New("no key has the token's kid")
+	ErrAlgMismatch     = errors.New("the key's alg doesn't match the token's alg")
+	ErrNoCompatibleKey = errors.New("no key can verify the token's alg")
+	ErrSignature       = errors.New("the signature doesn't verify")
+)
+
+// keyTypeFor is the kty of the keys that can verify an alg, "" for algs that can't be verified
+func keyTypeFor(alg string) jwa.KeyType {
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		return jwa.OctetSeq
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return jwa.RSA
+	case strings.HasPrefix(alg, "ES"):
+		return jwa.EC
+	case alg == jwa.EdDSA.String():
+		return jwa.OKP
+	}
+	return ""
+}
+
+// describe names a key in an error
+func describe(key jwk.Key) string {
+	if key.KeyID() == "" {
+		return "the key without a kid"
+	}
+	return fmt.Sprintf("the key with kid %q", key.KeyID())
+}
+
+// compatible reports whether the key can verify the alg. A key without an alg can verify any alg
+// for its kty. As the kty has to match, an HMAC alg is only ever verified with an oct key and never
+// with the public key of an RSA or EC key, the key confusion attack. The error says why it can't
+func compatible(key jwk.Key, alg string) error {
+	if use := key.KeyUsage(); use != "" && use != string(jwk.ForSignature) {
+		return fmt.Errorf("%w: %s is for %s, not signatures", ErrNoCompatibleKey, describe(key), use)
+	}
+	if key.KeyType() != keyTypeFor(alg) {
+		return fmt.Errorf("%w: %s is %s, %s needs %s", ErrNoCompatibleKey, describe(key), key.KeyType(), alg, keyTypeFor(alg))
+	}
+	if key.Algorithm() != nil && key.Algorithm().String() != "" && key.Algorithm().String() != alg {
+		return fmt.Errorf("%w: %s is for %s, the token is %s", ErrAlgMismatch, describe(key), key.Algorithm(), alg)
+	}
+	return nil
+}
+
+// SelectKeys picks the keys from the set that may have signed a token with the header. With a
+// kid only the keys with that kid are picked, a key without a kid is never used for a token with
+// one, or a token could pick any key by naming a kid that isn't there. Without a kid every key that
+// can verify the alg is picked. A key with an alg that isn't the header's alg is never picked
+func SelectKeys(set jwk.Set, header jws.Headers) ([]jwk.Key, error) {
+	alg := header.Algorithm().String()
+	kid := header.KeyID()
+	if keyTypeFor(alg) == "" {
+		return nil, fmt.Errorf("%w: %q isn't a signature alg that can be verified with a key", ErrNoCompatibleKey, alg)
+	}
+	var candidates []jwk.Key
+	var mismatch error
+	found, kidless := 0, 0
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if kid != "" && key.KeyID() != kid {
+			if key.KeyID() == "" {
+				kidless++
+			}
+			continue
+		}
+		found++
+		err := compatible(key, alg)
+		if err == nil {
+			candidates = append(candidates, key)
+			continue
+		}
+		// with a kid the reason the key can't be used is the error, without one an alg mismatch
+		// is more use than there being no key of the right type
+		if kid != "" || mismatch == nil || errors.Is(err, ErrAlgMismatch) {
+			mismatch = err
+		}
+	}
+	switch {
+	case len(candidates) > 0:
+		return candidates, nil
+	case found == 0 && kidless > 0:
+		return nil, fmt.Errorf("%w: %q, and keys without a kid are only used for tokens without one", ErrKidNotFound, kid)
+	case kid != "" && found == 0:
+		return nil, fmt.Errorf("%w: %q", ErrKidNotFound, kid)
+	case mismatch != nil && (kid != "" || errors.Is(mismatch, ErrAlgMismatch)):
+		return nil, mismatch
+	}
+	return nil, fmt.Errorf("%w: none of the %d keys can verify %s", ErrNoCompatibleKey, set.Len(), alg)
+}
+
+// verifySignatures checks the signatures of a parsed token with the keys SelectKeys picks for each
+// of them, returning the payload, the header of the signature that verified and the key that
+// verified it. One good signature is enough. A signature with an alg that isn't allowed isn't
+// tried, and alg none, when it's allowed, has no key. With the policy's Roots the key of a
+// certificate in the header that chains to them is tried first, and a key with an x5c that doesn't
+// chain to them isn't tried
+func verifySignatures(ctx context.Context, token []byte, msg *jws.Message, set jwk.Set, policy Policy) ([]byte, jws.Headers, jwk.Key, error) {
+	var firstErr error
+	for _, sig := range msg.Signatures() {
+		header := sig.ProtectedHeaders()
+		if err := checkHeader(header, policy); err != nil {
+			policy.explain("header", false, "%s: %v", summarize(header), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if header.Algorithm() == jwa.NoSignature {
+			if len(sig.Signature()) > 0 {
+				policy.explain("signature", false, "alg none with a signature")
+				return nil, nil, nil, fmt.Errorf("%w: alg none with a signature", ErrMalformed)
+			}
+			policy.explain("signature", true, "alg none is allowed, there is no signature to verify")
+			return msg.Payload(), header, nil, nil
+		}
+		certified, err := certifiedKey(ctx, header, policy)
+		if err == nil && certified != nil {
+			err = compatible(certified, header.Algorithm().String())
+		}
+		if err != nil {
+			policy.explain("certificate", false, "%v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		var candidates []jwk.Key
+		if certified != nil {
+			policy.explain("certificate", true, "the header's certificate chains to a trusted CA, kid %q", certified.KeyID())
+			candidates = append(candidates, certified)
+		}
+		selected, err := SelectKeys(set, header)
+		if err != nil && certified == nil {
+			policy.explain("candidates", false, "%v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		candidates = append(candidates, selected...)
+		policy.explain("candidates", true, "%s", describeKeys(candidates))
+		for _, key := range candidates {
+			if key != certified {
+				if err := checkKeyChain(key, policy); err != nil {
+					policy.explain("certificate", false, "%v", err)
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+			}
+			payload, err := jws.Verify(token, jws.WithKey(header.Algorithm(), key))
+			if err == nil {
+				policy.explain("signature", true, "verified with %s", describe(key))
+				return payload, header, key, nil
+			}
+			policy.explain("signature", false, "%s: %v", describe(key), err)
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%w with any of the %d keys that can verify %s", ErrSignature, len(candidates), header.Algorithm())
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("%w: the token has no signature", ErrMalformed)
+	}
+	return nil, nil, nil, firstErr
+}
@@ -0,0 +1,107 @@
+package jwttools
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// testSet is a JWKS with a key for each way a key can be picked or not
+func testSet(t *testing.T) jwk.Set {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	add := func(raw interface{}, fields map[string]string) {
+		key, err := jwk.FromRaw(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range fields {
+			if err := key.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		set.AddKey(key)
+	}
+	add(rsaKey.Public(), map[string]string{"kid": "rs256", "alg": "RS256"})
+	add(rsaKey.Public(), map[string]string{"kid": "rsa"})
+	add(rsaKey.Public(), map[string]string{})
+	add(rsaKey.Public(), map[string]string{"kid": "encryption", "use": "enc"})
+	add(ecKey.Public(), map[string]string{"kid": "ec"})
+	add([]byte("a secret that is long enough for HS256"), map[string]string{"kid": "hmac"})
+	return set
+}
+
+func TestSelectKeys(t *testing.T) {
+	set := testSet(t)
+	tests := []struct {
+		name string
+		alg  string
+		kid  string
+		want []string // the kids of the keys picked
+		err  error
+	}{
+		{"kid", "RS256", "rs256", []string{"rs256"}, nil},
+		{"no kid", "RS256", "", []string{"rs256", "rsa", ""}, nil},
+		{"no kid other alg", "RS384", "", []string{"rsa", ""}, nil},
+		{"EC", "ES256", "ec", []string{"ec"}, nil},
+		{"HMAC", "HS256", "", []string{"hmac"}, nil},
+		{"unknown kid", "RS256", "missing", nil, ErrKidNotFound},
+		{"key's alg", "RS384", "rs256", nil, ErrAlgMismatch},
+		{"key's kty", "RS256", "ec", nil, ErrNoCompatibleKey},
+		{"key confusion", "HS256", "rsa", nil, ErrNoCompatibleKey},
+		{"encryption key", "RS256", "encryption", nil, ErrNoCompatibleKey},
+		{"no key for the alg", "EdDSA", "", nil, ErrNoCompatibleKey},
+		{"alg none", "none", "", nil, ErrNoCompatibleKey},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := jws.NewHeaders()
+			header.Set(jws.AlgorithmKey, test.alg)
+			if test.kid != "" {
+				header.Set(jws.KeyIDKey, test.kid)
+			}
+			selected, err := SelectKeys(set, header)
+			if !errors.Is(err, test.err) || (err != nil) != (test.err != nil) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			var kids []string
+			for _, key := range selected {
+				kids = append(kids, key.KeyID())
+			}
+			if !reflect.DeepEqual(kids, test.want) {
+				t.Errorf("picked %q, want %q", kids, test.want)
+			}
+		})
+	}
+}
+
+// A token naming a kid that isn't in the set is never verified with a key without a kid, or
+// anyone could make a token that verifies by naming a kid that doesn't exist
+func TestSelectKeysWrongKid(t *testing.T) {
+	set := testSet(t)
+	header := jws.NewHeaders()
+	header.Set(jws.AlgorithmKey, "RS256")
+	header.Set(jws.KeyIDKey, "attacker")
+	selected, err := SelectKeys(set, header)
+	if !errors.Is(err, ErrKidNotFound) {
+		t.Fatalf("got %d keys and %v, want ErrKidNotFound", len(selected), err)
+	}
+	if code, _ := ErrorCode(err); code != "kid_not_found" {
+		t.Errorf("got code %q, want kid_not_found", code)
+	}
+}
@@ -0,0 +1,148 @@
+package jwttools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// The ways Verify can refuse a token before trying any keys
+var (
+	ErrMalformed       = errors.New("the token is malformed")
+	ErrAlgNotAllowed   = errors.New("the token's alg is not allowed")
+	ErrUnknownCritical = errors.New("the token has a crit header naming an extension that isn't understood")
+)
+
+// understoodCritical are the extensions that can be named in crit, jwx verifies b64 (RFC 7797)
+var understoodCritical = []string{"b64"}
+
+// AlgAllowed reports whether a token signed with the alg is allowed by the list. none is only
+// allowed when it's in the list, everything else that can be verified with a key is allowed by an
+// empty list
+func AlgAllowed(alg string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return alg != jwa.NoSignature.String() && keyTypeFor(alg) != ""
+	}
+	return contains(allowed, alg)
+}
+
+// checkHeader refuses a signature whose header has an alg that isn't allowed or a crit that names
+// anything that isn't understood. The crit is only checked when the token is being validated
+func checkHeader(header jws.Headers, policy Policy) error {
+	allowed := policy.Algorithms
+	alg := header.Algorithm().String()
+	if !AlgAllowed(alg, allowed) {
+		if len(allowed) == 0 {
+			return fmt.Errorf("%w: %q", ErrAlgNotAllowed, alg)
+		}
+		return fmt.Errorf("%w: %q is not one of %s", ErrAlgNotAllowed, alg, strings.Join(allowed, ", "))
+	}
+	raw, ok := header.Get(jws.CriticalKey)
+	if !ok || policy.SkipValidation {
+		return nil
+	}
+	critical, _ := raw.([]string)
+	// RFC 7515 section 4.1.11, crit can't be empty and everything in it has to be in the header
+	if len(critical) == 0 {
+		return fmt.Errorf("%w: crit is empty", ErrMalformed)
+	}
+	for _, name := range critical {
+		if !contains(understoodCritical, name) {
+			return fmt.Errorf("%w: %q", ErrUnknownCritical, name)
+		}
+		if _, ok := header.Get(name); !ok {
+			return fmt.Errorf("%w: crit names %q but it isn't in the header", ErrMalformed, name)
+		}
+	}
+	return nil
+}
+
+// checkEncoding refuses a token that other verifiers could read differently: base64url that isn't
+// in its one canonical form, with padding or with the unused bits set, and JSON objects with the
+// same member more than once
+func checkEncoding(token string) error {
+	var parts []string
+	if strings.HasPrefix(token, "{") {
+		var message struct {
+			Payload    string `json:"payload"`
+			Protected  string `json:"protected"`
+			Signature  string `json:"signature"`
+			Signatures []struct {
+				Protected string `json:"protected"`
+				Signature string `json:"signature"`
+			} `json:"signatures"`
+		}
+		if err := json.Unmarshal([]byte(token), &message); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		parts = append(parts, message.Protected, message.Payload, message.Signature)
+		for _, sig := range message.Signatures {
+			parts = append(parts, sig.Protected, sig.Signature)
+		}
+	} else {
+		parts = strings.Split(token, ".")
+	}
+	for i, part := range parts {
+		decoded, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil || base64.RawURLEncoding.EncodeToString(decoded) != part {
+			return fmt.Errorf("%w: part %d is not canonical unpadded base64url", ErrMalformed, i+1)
+		}
+		if len(decoded) > 0 && decoded[0] == '{' {
+			if name, ok := duplicateMember(decoded); ok {
+				return fmt.Errorf("%w: part %d has %q more than once", ErrMalformed, i+1, name)
+			}
+		}
+	}
+	return nil
+}
+
+// duplicateMember finds a member that's in a JSON object more than once, at any depth
+func duplicateMember(data []byte) (string, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// one set of member names for each object being read, nil for arrays
+	var stack []map[string]bool
+	// expectKey is whether the next string is a member name
+	var expectKey []bool
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+		switch t := token.(type) {
+		case json.Delim:
+			switch t {
+			case '{':
+				stack = append(stack, map[string]bool{})
+				expectKey = append(expectKey, true)
+				continue
+			case '[':
+				stack = append(stack, nil)
+				expectKey = append(expectKey, false)
+				continue
+			default:
+				stack = stack[:len(stack)-1]
+				expectKey = expectKey[:len(expectKey)-1]
+			}
+		case string:
+			top := len(stack) - 1
+			if top >= 0 && stack[top] != nil && expectKey[top] {
+				if stack[top][t] {
+					return t, true
+				}
+				stack[top][t] = true
+				expectKey[top] = false
+				continue
+			}
+		}
+		// a value was read, in an object the next string is a member name again
+		if top := len(stack) - 1; top >= 0 && stack[top] != nil {
+			expectKey[top] = true
+		}
+	}
+}
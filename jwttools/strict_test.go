@@ -0,0 +1,128 @@
+package jwttools
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+func TestCheckHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		policy Policy
+		err    error
+	}{
+		{"any alg", map[string]interface{}{"alg": "RS256"}, Policy{}, nil},
+		{"alg none", map[string]interface{}{"alg": "none"}, Policy{}, ErrAlgNotAllowed},
+		{"alg none listed", map[string]interface{}{"alg": "none"}, Policy{Algorithms: []string{"none"}}, nil},
+		{"alg listed", map[string]interface{}{"alg": "ES256"}, Policy{Algorithms: []string{"RS256", "ES256"}}, nil},
+		{"alg not listed", map[string]interface{}{"alg": "HS256"}, Policy{Algorithms: []string{"RS256"}}, ErrAlgNotAllowed},
+		{"crit b64", map[string]interface{}{"alg": "RS256", "b64": false, "crit": []string{"b64"}}, Policy{}, nil},
+		{"crit unknown", map[string]interface{}{"alg": "RS256", "exp": 1, "crit": []string{"exp"}}, Policy{}, ErrUnknownCritical},
+		{"crit not in header", map[string]interface{}{"alg": "RS256", "crit": []string{"b64"}}, Policy{}, ErrMalformed},
+		{"crit empty", map[string]interface{}{"alg": "RS256", "crit": []string{}}, Policy{}, ErrMalformed},
+		{"crit unknown not validated", map[string]interface{}{"alg": "RS256", "exp": 1, "crit": []string{"exp"}}, Policy{SkipValidation: true}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := jws.NewHeaders()
+			for name, value := range test.fields {
+				if err := header.Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := checkHeader(header, test.policy)
+			if !errors.Is(err, test.err) || (err != nil) != (test.err != nil) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestCheckEncoding(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	header, payload, signature := enc([]byte(`{"alg":"RS256"}`)), enc([]byte(`{"sub":"a"}`)), enc([]byte("signature"))
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"compact", header + "." + payload + "." + signature, true},
+		{"padded", header + "." + enc([]byte(`{"sub":"ab"}`)) + "==." + signature, false},
+		{"standard base64", header + "." + payload + "." + base64.RawStdEncoding.EncodeToString([]byte{0xfb, 0xff}), false},
+		{"unused bits set", header + "." + payload + "." + "QR", false},
+		{"duplicate header member", enc([]byte(`{"alg":"RS256","alg":"none"}`)) + "." + payload + "." + signature, false},
+		{"duplicate claim", header + "." + enc([]byte(`{"sub":"a","sub":"b"}`)) + "." + signature, false},
+		{"flattened", `{"protected":"` + header + `","payload":"` + payload + `","signature":"` + signature + `"}`, true},
+		{"flattened duplicate", `{"protected":"` + header + `","payload":"` + enc([]byte(`{"a":{"b":1,"b":2}}`)) + `","signature":"` + signature + `"}`, false},
+		{"general padded", `{"payload":"` + payload + `","signatures":[{"protected":"` + header + `=","signature":"` + signature + `"}]}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkEncoding(test.token)
+			if test.ok && err != nil {
+				t.Errorf("got %v", err)
+			}
+			if !test.ok && !errors.Is(err, ErrMalformed) {
+				t.Errorf("got %v, want ErrMalformed", err)
+			}
+		})
+	}
+}
+
+func TestDuplicateMember(t *testing.T) {
+	tests := []struct {
+		json string
+		want string // the member that's there twice, "" for none
+	}{
+		{`{"a":1,"b":2}`, ""},
+		{`{"a":1,"a":2}`, "a"},
+		{`{"a":{"b":1,"b":2}}`, "b"},
+		{`{"a":"a","b":"a"}`, ""},
+		{`{"a":1,"b":{"a":1}}`, ""},
+		{`{"a":[{"b":1},{"b":2}]}`, ""},
+		{`{"a":[{"b":1,"b":2}]}`, "b"},
+		{`{"a":[1,{"c":1}],"c":2,"a":3}`, "a"},
+		{`not json`, ""},
+	}
+	for _, test := range tests {
+		name, found := duplicateMember([]byte(test.json))
+		if name != test.want || found != (test.want != "") {
+			t.Errorf("duplicateMember(%s) = %q, %v, want %q", test.json, name, found, test.want)
+		}
+	}
+}
+
+// Every broken token mint --attack makes is rejected by Verify with the key it's made from
+func TestVerifyRejectsAttacks(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyKey := &keys.Key{Public: key.Public(), KeyID: "k1"}
+	signer := Signer{Algorithm: "RS256", Key: key, KeyID: "k1"}
+	token, err := Mint(ctx, Claims{"sub": "alice"}, signer, MintOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(ctx, token, StaticKeys{verifyKey}, Policy{}); err != nil {
+		t.Fatalf("the good token doesn't verify: %v", err)
+	}
+	for _, attack := range Attacks {
+		token, err := MintAttack(ctx, attack.Name, Claims{"sub": "alice"}, signer, MintOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Verify(ctx, token, StaticKeys{verifyKey}, Policy{}); err == nil {
+			t.Errorf("%s verified", attack.Name)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package jwttools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ps258/jwt-tools/keys"
+	"gopkg.in/yaml.v3"
+)
+
+// Anchor is how the tokens from one issuer are trusted: where its keys are and what its tokens
+// have to satisfy. Only one of JWKSURI, JWKSFile and PEM can be set, with none of them the keys
+// are found by discovering the issuer's metadata
+type Anchor struct {
+	JWKSURI        string   `yaml:"jwks_uri"`
+	JWKSFile       string   `yaml:"jwks_file"`
+	PEM            string   `yaml:"pem"`             // a key or certificate file, or the PEM itself
+	Audiences      []string `yaml:"audiences"`       // the aud must contain one of these
+	Algorithms     []string `yaml:"algs"`            // the algs the issuer signs with
+	RequiredClaims []string `yaml:"required_claims"` // claims every token from the issuer must have
+	Leeway         *Leeway  `yaml:"leeway"`          // the skew allowed for exp, nbf and iat, nil for the policy's
+	Policy         []string `yaml:"policy"`          // rules the claims have to satisfy, see Rule
+	rules          []*Rule
+}
+
+// Leeway is a duration written as a Go duration, 30s or 2m, or as a number of seconds
+type Leeway time.Duration
+
+func (l *Leeway) UnmarshalYAML(node *yaml.Node) error {
+	var seconds int
+	if err := node.Decode(&seconds); err == nil {
+		*l = Leeway(time.Duration(seconds) * time.Second)
+		return nil
+	}
+	d, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: leeway %q is neither a duration nor a number of seconds", node.Line, node.Value)
+	}
+	*l = Leeway(d)
+	return nil
+}
+
+// TrustConfig maps each trusted iss to its Anchor
+type TrustConfig map[string]Anchor
+
+// LoadTrustConfig reads a trust config from a YAML or JSON file. Relative jwks_file and pem paths
+// are relative to the file
+func LoadTrustConfig(filename string) (TrustConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, so one parser reads both
+	var config TrustConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if len(config) == 0 {
+		return nil, fmt.Errorf("%s: no issuers", filename)
+	}
+	dir := filepath.Dir(filename)
+	for iss, anchor := range config {
+		if err := anchor.check(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, iss, err)
+		}
+		if anchor.rules, err = ParseRules(anchor.Policy); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, iss, err)
+		}
+		if anchor.JWKSFile != "" && !filepath.IsAbs(anchor.JWKSFile) {
+			anchor.JWKSFile = filepath.Join(dir, anchor.JWKSFile)
+		}
+		if anchor.PEM != "" && !isPEM(anchor.PEM) && !filepath.IsAbs(anchor.PEM) {
+			anchor.PEM = filepath.Join(dir, anchor.PEM)
+		}
+		config[iss] = anchor
+	}
+	return config, nil
+}
+
+// check reports an anchor with more than one place for its keys or that can never be satisfied
+func (a Anchor) check() error {
+	given := 0
+	for _, place := range []string{a.JWKSURI, a.JWKSFile, a.PEM} {
+		if place != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return fmt.Errorf("only one of jwks_uri, jwks_file and pem can be given")
+	}
+	for _, alg := range a.Algorithms {
+		if alg != "none" && !AlgAllowed(alg, nil) {
+			return fmt.Errorf("unknown alg %q", alg)
+		}
+	}
+	if a.Leeway != nil && *a.Leeway < 0 {
+		return fmt.Errorf("the leeway can't be negative")
+	}
+	return nil
+}
+
+func isPEM(s string) bool {
+	return strings.Contains(s, "-----BEGIN ")
+}
+
+// Issuers are the trusted issuers, sorted
+func (c TrustConfig) Issuers() []string {
+	issuers := make([]string, 0, len(c))
+	for iss := range c {
+		issuers = append(issuers, iss)
+	}
+	sort.Strings(issuers)
+	return issuers
+}
+
+// KeySource is where the keys of the issuer are, its metadata is discovered when the anchor
+// doesn't say. The algs are the ones the discovered metadata says the issuer signs with, nil when
+// there was no discovery or it doesn't say
+func (a Anchor) KeySource(ctx context.Context, client *http.Client, iss string) (KeySource, []string, error) {
+	switch {
+	case a.JWKSURI != "":
+		return JWKSURL{URL: a.JWKSURI, Client: client}, nil, nil
+	case a.JWKSFile != "":
+		return JWKSFile(a.JWKSFile), nil, nil
+	case isPEM(a.PEM):
+		found, err := keys.Parse([]byte(a.PEM), "")
+		if err != nil {
+			return nil, nil, err
+		}
+		return StaticKeys(found), nil, nil
+	case a.PEM != "":
+		found, err := keys.Load(a.PEM, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		return StaticKeys(found), nil, nil
+	}
+	md, err := Discover(ctx, client, iss)
+	if err != nil {
+		return nil, nil, err
+	}
+	return md.KeySource(client), md.Algorithms, nil
+}
+
+// Restrict narrows the policy to the anchor: the iss has to be the issuer, the aud one of its
+// audiences, the alg one of its algs, and the claims it requires have to be there and satisfy its
+// rules. Its leeway, even 0, replaces the policy's when it has one
+func (a Anchor) Restrict(policy Policy, iss string) (Policy, error) {
+	policy.Issuer = iss
+	if len(a.Audiences) > 0 {
+		policy.Audiences = a.Audiences
+	}
+	policy.RequiredClaims = append(append([]string{}, policy.RequiredClaims...), a.RequiredClaims...)
+	policy.Rules = append(append([]*Rule{}, policy.Rules...), a.rules...)
+	if a.Leeway != nil {
+		policy.AcceptableSkew = time.Duration(*a.Leeway)
+	}
+	return restrictAlgs(policy, iss, a.Algorithms)
+}
+
+// Route picks the anchor for the token by its iss, read without verifying it, and gives the keys
+// to verify it with and the policy narrowed to the anchor, and to the algs the issuer's metadata
+// advertises when it's discovered. A token whose iss isn't in the config is refused with
+// ErrUntrustedIssuer before any keys are fetched
+func (c TrustConfig) Route(ctx context.Context, client *http.Client, token string, policy Policy) (KeySource, Policy, error) {
+	iss, err := TrustedIssuer(token, c.Issuers(), policy.DecryptionKeys...)
+	if err != nil {
+		policy.explain("issuer", false, "%v", err)
+		return nil, policy, err
+	}
+	anchor := c[iss]
+	source, algs, err := anchor.KeySource(ctx, client, iss)
+	if err != nil {
+		policy.explain("issuer", false, "%s: %v", iss, err)
+		return nil, policy, fmt.Errorf("%w: %s: %v", ErrKeySource, iss, err)
+	}
+	if policy, err = anchor.Restrict(policy, iss); err != nil {
+		policy.explain("issuer", false, "%s: %v", iss, err)
+		return nil, policy, err
+	}
+	if policy, err = restrictAlgs(policy, iss, algs); err != nil {
+		policy.explain("issuer", false, "%s: %v", iss, err)
+		return nil, policy, err
+	}
+	policy.explain("issuer", true, "%s is trusted", iss)
+	return source, policy, nil
+}
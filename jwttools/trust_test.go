@@ -0,0 +1,147 @@
+package jwttools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTrustConfig(t *testing.T, config string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "trust.yaml")
+	if err := os.WriteFile(filename, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadTrustConfig(t *testing.T) {
+	filename := writeTrustConfig(t, `
+https://a.example.com:
+  jwks_file: a.json
+  audiences: [orders]
+  algs: [ES256]
+  required_claims: [sub]
+  leeway: 30
+  policy: [scope contains orders:write]
+https://b.example.com:
+  leeway: 2m
+https://c.example.com:
+  leeway: 0
+https://d.example.com: {}
+`)
+	config, err := LoadTrustConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://a.example.com", "https://b.example.com", "https://c.example.com", "https://d.example.com"}; !reflect.DeepEqual(config.Issuers(), want) {
+		t.Errorf("got issuers %v", config.Issuers())
+	}
+	a := config["https://a.example.com"]
+	if a.JWKSFile != filepath.Join(filepath.Dir(filename), "a.json") || a.Leeway == nil || *a.Leeway != Leeway(30*time.Second) {
+		t.Errorf("got %+v", a)
+	}
+	if policy, err := a.Restrict(Policy{}, "https://a.example.com"); err != nil || len(policy.Rules) != 1 || policy.Rules[0].Text != "scope contains orders:write" {
+		t.Errorf("got rules %v, %v", policy.Rules, err)
+	}
+	if b := config["https://b.example.com"]; b.Leeway == nil || *b.Leeway != Leeway(2*time.Minute) {
+		t.Errorf("got %+v", b)
+	}
+	if c := config["https://c.example.com"]; c.Leeway == nil || *c.Leeway != 0 {
+		t.Errorf("an explicit leeway of 0 was lost: %+v", c)
+	}
+	if d := config["https://d.example.com"]; d.Leeway != nil {
+		t.Errorf("got a leeway without one: %+v", d)
+	}
+
+	json, err := LoadTrustConfig(writeTrustConfig(t, `{"https://a.example.com": {"jwks_uri": "https://a.example.com/keys", "leeway": "10s"}}`))
+	if err != nil || json["https://a.example.com"].JWKSURI != "https://a.example.com/keys" {
+		t.Errorf("JSON: got %+v, %v", json, err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"https://a.example.com:\n  jwks_uri: https://a.example.com/keys\n  jwks_file: a.json\n",
+		"https://a.example.com:\n  algs: [XS256]\n",
+		"https://a.example.com:\n  leeway: -5\n",
+		"https://a.example.com:\n  leeway: soon\n",
+		"https://a.example.com:\n  policy: [scope between a]\n",
+	} {
+		if _, err := LoadTrustConfig(writeTrustConfig(t, bad)); err == nil {
+			t.Errorf("%q: loaded", bad)
+		}
+	}
+}
+
+// The anchor's leeway replaces the policy's even when it's 0, and is left alone when there isn't one
+func TestAnchorRestrict(t *testing.T) {
+	zero, minute := Leeway(0), Leeway(time.Minute)
+	policy := Policy{AcceptableSkew: time.Hour, RequiredClaims: []string{"exp"}}
+	tests := []struct {
+		leeway *Leeway
+		want   time.Duration
+	}{
+		{nil, time.Hour},
+		{&zero, 0},
+		{&minute, time.Minute},
+	}
+	for _, test := range tests {
+		got, err := Anchor{Leeway: test.leeway, RequiredClaims: []string{"sub"}}.Restrict(policy, "https://a.example.com")
+		if err != nil || got.AcceptableSkew != test.want {
+			t.Errorf("%v: got %v, %v, want %v", test.leeway, got.AcceptableSkew, err, test.want)
+		}
+		if got.Issuer != "https://a.example.com" || !reflect.DeepEqual(got.RequiredClaims, []string{"exp", "sub"}) {
+			t.Errorf("got %+v", got)
+		}
+	}
+	if len(policy.RequiredClaims) != 1 {
+		t.Errorf("the policy's required claims were changed: %v", policy.RequiredClaims)
+	}
+}
+
+// Routing by the iss refuses other issuers before fetching anything, and narrows the algs of a
+// discovered issuer to the ones its metadata advertises as well as the anchor's
+func TestRoute(t *testing.T) {
+	ctx := context.Background()
+	server := testIssuer(t, "/.well-known/openid-configuration",
+		`{"issuer":"%[1]s","jwks_uri":"%[1]s/jwks","id_token_signing_alg_values_supported":["RS256","ES256"]}`)
+	config := TrustConfig{
+		server.URL:              {},
+		"https://a.example.com": {JWKSURI: "https://a.example.com/keys", Algorithms: []string{"PS256"}},
+	}
+	secret := make([]byte, 32)
+	token := func(iss string) string {
+		token, err := Mint(ctx, Claims{"iss": iss}, NewHMACSigner(secret), MintOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	source, policy, err := config.Route(ctx, server.Client(), token(server.URL), Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.(JWKSURL).URL != server.URL+"/jwks" || policy.Issuer != server.URL || !reflect.DeepEqual(policy.Algorithms, []string{"RS256", "ES256"}) {
+		t.Errorf("discovered: got %+v, %+v", source, policy)
+	}
+	config[server.URL] = Anchor{Algorithms: []string{"ES256", "PS256"}}
+	if _, policy, err := config.Route(ctx, server.Client(), token(server.URL), Policy{}); err != nil || !reflect.DeepEqual(policy.Algorithms, []string{"ES256"}) {
+		t.Errorf("discovered with algs: got %v, %v", policy.Algorithms, err)
+	}
+	config[server.URL] = Anchor{Algorithms: []string{"PS256"}}
+	if _, _, err := config.Route(ctx, server.Client(), token(server.URL), Policy{}); err == nil {
+		t.Errorf("routed with none of the advertised algs allowed")
+	}
+
+	if _, policy, err := config.Route(ctx, nil, token("https://a.example.com"), Policy{}); err != nil || !reflect.DeepEqual(policy.Algorithms, []string{"PS256"}) {
+		t.Errorf("jwks_uri: got %v, %v", policy.Algorithms, err)
+	}
+	if _, _, err := config.Route(ctx, nil, token("https://evil.example.com"), Policy{}); !errors.Is(err, ErrUntrustedIssuer) {
+		t.Errorf("got %v, want ErrUntrustedIssuer", err)
+	}
+}
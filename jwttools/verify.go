@@ -0,0 +1,277 @@
+package jwttools
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/ps258/jwt-tools/keys"
+)
+
+// KeySource provides the keys a token can be verified with
+type KeySource interface {
+	KeySet(ctx context.Context) (jwk.Set, error)
+}
+
+// JWKSURL fetches the keys from a JWKS endpoint
+type JWKSURL struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+func (s JWKSURL) KeySet(ctx context.Context) (jwk.Set, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return jwk.Fetch(ctx, s.URL, jwk.WithHTTPClient(client))
+}
+
+// JWKSFile reads the keys from a JWKS file
+type JWKSFile string
+
+func (s JWKSFile) KeySet(ctx context.Context) (jwk.Set, error) {
+	return jwk.ReadFile(string(s))
+}
+
+// StaticKeys are keys already loaded by the keys package. Only their public half is used. Unlike
+// a JWKS built from them they only have a kid and alg if they came with one, so that a key from a
+// PEM file can verify a token without a kid with any alg its type can
+type StaticKeys []*keys.Key
+
+func (s StaticKeys) KeySet(ctx context.Context) (jwk.Set, error) {
+	set, err := BuildJWKS(s, JWKSOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range s {
+		j, _ := set.Key(i)
+		if key.KeyID == "" {
+			j.Remove(jwk.KeyIDKey)
+		}
+		if key.Algorithm == "" {
+			j.Remove(jwk.AlgorithmKey)
+		}
+	}
+	return set, nil
+}
+
+// HMACSecret is a shared secret for HS256 tokens
+type HMACSecret []byte
+
+func (s HMACSecret) KeySet(ctx context.Context) (jwk.Set, error) {
+	key, err := jwk.FromRaw([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+	key.Set(jwk.AlgorithmKey, jwa.HS256)
+	set := jwk.NewSet()
+	set.AddKey(key)
+	return set, nil
+}
+
+// KeySources combines the keys from several sources
+type KeySources []KeySource
+
+func (s KeySources) KeySet(ctx context.Context) (jwk.Set, error) {
+	all := jwk.NewSet()
+	for _, source := range s {
+		set, err := source.KeySet(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < set.Len(); i++ {
+			key, _ := set.Key(i)
+			all.AddKey(key)
+		}
+	}
+	return all, nil
+}
+
+// Policy is what a token has to satisfy beyond having a good signature
+type Policy struct {
+	Issuer         string         // the iss must be this when it's set
+	Audience       string         // the aud must contain this when it's set
+	Audiences      []string       // the aud must contain one of these when there are any
+	RequiredClaims []string       // claims the token must have
+	AcceptableSkew time.Duration  // leeway for exp, nbf and iat
+	SkipValidation bool           // only check the signature, not exp, nbf, iat, crit or the strict encoding
+	Algorithms     []string       // the algs the token can be signed with, any that need a key if empty. none has to be listed to be allowed
+	DecryptionKeys []interface{}  // private keys, or []byte secrets for dir, to decrypt a JWE with
+	Rules          []*Rule        // rules the claims have to satisfy, every one of them
+	Roots          *x509.CertPool // CAs that a certificate in the header can chain to, and that a key with an x5c has to
+	X5UPrefixes    []string       // the https URLs an x5u can be fetched from, it's ignored without them
+	Client         *http.Client   // fetches the x5u, http.DefaultClient if nil
+	Explain        func(Step)     // called with each step Verify takes when it's set
+}
+
+// Result is what was found in a verified token
+type Result struct {
+	Encryption map[string]interface{} // the JWE header when the token is encrypted
+	Header     map[string]interface{} // the JWS header, nil for an encrypted token that isn't signed
+	Claims     map[string]interface{}
+	KeyID      string       // the kid of the key that verified the token, or the header's if it has none
+	Key        jwk.Key      // the key that verified the token, nil for alg none and from Decode
+	Rules      []RuleResult // how the claims did against each of the policy's rules
+}
+
+// ErrKeySource is returned by Verify when the keys can't be had from the KeySource, as opposed
+// to the token not verifying with them
+var ErrKeySource = errors.New("unable to get the keys")
+
+// Decode reads the header and claims of a token without verifying it. An encrypted token is
+// decrypted with the first of the keys that works, without any keys only its JWE header is read
+func Decode(token string, decryptionKeys ...interface{}) (*Result, error) {
+	if !IsEncrypted(token) {
+		return decodeSigned(token)
+	}
+	encryption, payload, err := Decrypt(token, decryptionKeys)
+	if err != nil {
+		if encryption != nil && len(decryptionKeys) == 0 {
+			return &Result{Encryption: encryption}, nil
+		}
+		return nil, err
+	}
+	result := &Result{}
+	if isNested(encryption, payload) {
+		if result, err = decodeSigned(string(payload)); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(payload, &result.Claims); err != nil {
+		return nil, fmt.Errorf("the payload is not a JSON object: %w", err)
+	}
+	result.Encryption = encryption
+	return result, nil
+}
+
+// isNested reports whether a decrypted payload is a signed token rather than the claims. The cty
+// should say so but a compact JWS can't be mistaken for a JSON object anyway
+func isNested(encryption map[string]interface{}, payload []byte) bool {
+	if cty, _ := encryption["cty"].(string); strings.EqualFold(cty, "JWT") {
+		return true
+	}
+	return strings.Count(string(payload), ".") == 2 && !strings.HasPrefix(strings.TrimSpace(string(payload)), "{")
+}
+
+func decodeSigned(token string) (*Result, error) {
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if len(msg.Signatures()) == 0 {
+		return nil, fmt.Errorf("%w: the token has no signature", ErrMalformed)
+	}
+	result := &Result{}
+	if result.Header, err = msg.Signatures()[0].ProtectedHeaders().AsMap(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(msg.Payload(), &result.Claims); err != nil {
+		return nil, fmt.Errorf("%w: the payload is not a JSON object: %v", ErrMalformed, err)
+	}
+	result.KeyID = msg.Signatures()[0].ProtectedHeaders().KeyID()
+	return result, nil
+}
+
+// audienceIn validates that the aud has one of the audiences in it
+func audienceIn(audiences []string) jwt.ValidatorFunc {
+	return func(_ context.Context, t jwt.Token) jwt.ValidationError {
+		for _, aud := range t.Audience() {
+			if contains(audiences, aud) {
+				return nil
+			}
+		}
+		return jwt.NewValidationError(fmt.Errorf("aud not satisfied: %v is not one of %s", t.Audience(), strings.Join(audiences, ", ")))
+	}
+}
+
+// Verify checks the signature of the token against the keys from the source and validates its
+// claims against the policy. An encrypted token is decrypted with the policy's DecryptionKeys and
+// has to hold a signed token, RFC 7519 section 5.2
+func Verify(ctx context.Context, token string, source KeySource, policy Policy) (*Result, error) {
+	var encryption map[string]interface{}
+	if IsEncrypted(token) {
+		var payload []byte
+		var err error
+		if encryption, payload, err = Decrypt(token, policy.DecryptionKeys); err != nil {
+			policy.explain("decrypt", false, "%v", err)
+			return nil, err
+		}
+		if !isNested(encryption, payload) {
+			err = fmt.Errorf("%w: the encrypted token isn't signed, there is no signature to verify", ErrSignature)
+			policy.explain("decrypt", false, "%v", err)
+			return nil, err
+		}
+		policy.explain("decrypt", true, "alg %v, enc %v", encryption["alg"], encryption["enc"])
+		token = string(payload)
+	}
+
+	if !policy.SkipValidation {
+		if err := checkEncoding(token); err != nil {
+			policy.explain("encoding", false, "%v", err)
+			return nil, err
+		}
+		policy.explain("encoding", true, "canonical base64url, no duplicate members")
+	}
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		policy.explain("header", false, "%v", err)
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	for _, sig := range msg.Signatures() {
+		policy.explain("header", true, "%s", summarize(sig.ProtectedHeaders()))
+	}
+
+	set, err := source.KeySet(ctx)
+	if err != nil {
+		policy.explain("keys", false, "%v", err)
+		return nil, fmt.Errorf("%w: %v", ErrKeySource, err)
+	}
+	policy.explain("keys", true, "%d keys", set.Len())
+
+	// the signature is checked here rather than by jwt.Parse, which takes the flattened JSON
+	// serialization for the claims and lets jwx pick the keys
+	payload, header, key, err := verifySignatures(ctx, []byte(token), msg, set, policy)
+	if err != nil {
+		return nil, err
+	}
+	// the claims are checked one at a time rather than by jwt.Parse so each can be explained
+	t, err := jwt.Parse(payload, jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		policy.explain("claims", false, "%v", err)
+		return nil, fmt.Errorf("%w: the claims can't be read: %v", ErrMalformed, err)
+	}
+	if !policy.SkipValidation {
+		if err := policy.validateClaims(ctx, t); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &Result{Encryption: encryption, Key: key}
+	if key != nil {
+		result.KeyID = key.KeyID()
+	}
+	if result.KeyID == "" {
+		result.KeyID = header.KeyID()
+	}
+	if result.Header, err = header.AsMap(ctx); err != nil {
+		return nil, err
+	}
+	if result.Claims, err = t.AsMap(ctx); err != nil {
+		return nil, err
+	}
+	if !policy.SkipValidation && len(policy.Rules) > 0 {
+		if result.Rules, err = policy.evaluateRules(result.Claims); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
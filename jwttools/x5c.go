@@ -0,0 +1,236 @@
+package jwttools
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/cert"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// ErrCertificate is returned when a certificate chain in the token's header or in a key doesn't
+// validate against the policy's Roots, or doesn't match the key or its thumbprints
+var ErrCertificate = errors.New("the certificate chain doesn't validate")
+
+// decodeChain decodes an x5c, the leaf certificate first
+func decodeChain(chain *cert.Chain) ([]*x509.Certificate, error) {
+	if chain == nil {
+		return nil, nil
+	}
+	var certs []*x509.Certificate
+	for i := 0; i < chain.Len(); i++ {
+		encoded, _ := chain.Get(i)
+		der, err := base64.StdEncoding.DecodeString(string(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("x5c[%d]: %w", i, err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("x5c[%d]: %w", i, err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+// allowedX5U reports why an x5u can't be fetched. It has to be https, so that RFC 7515 section
+// 4.1.5's validated TLS is had, and under one of the prefixes. A prefix only matches up to a path
+// boundary, so https://idp.example.com doesn't let https://idp.example.com.evil.net in
+func allowedX5U(location string, prefixes []string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.User != nil {
+		return fmt.Errorf("%s isn't an https URL", location)
+	}
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(location, prefix) {
+			continue
+		}
+		if rest := location[len(prefix):]; strings.HasSuffix(prefix, "/") || rest == "" || strings.ContainsAny(rest[:1], "/?#") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s isn't under any of the x5u prefixes", location)
+}
+
+// fetchChain reads the PEM certificates at an x5u, RFC 7515 section 4.1.5, with the policy's
+// Client. The x5u, and any redirect, has to be allowed by the policy's X5UPrefixes
+func fetchChain(ctx context.Context, location string, policy Policy) ([]*x509.Certificate, error) {
+	if err := allowedX5U(location, policy.X5UPrefixes); err != nil {
+		return nil, err
+	}
+	client := http.DefaultClient
+	if policy.Client != nil {
+		client = policy.Client
+	}
+	// a copy, so the redirects are checked without changing the policy's client
+	checked := *client
+	checked.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return allowedX5U(req.URL.String(), policy.X5UPrefixes)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := checked.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", location, err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no PEM certificates", location)
+	}
+	return certs, nil
+}
+
+// validateChain checks that the leaf chains to one of the roots through the rest of the
+// certificates, that every one of them is in date and that the leaf can be used for signatures
+func validateChain(certs []*x509.Certificate, roots *x509.CertPool) error {
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	// any extended key usage will do, it's the key usage that says whether it can sign
+	options := x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err := leaf.Verify(options); err != nil {
+		return fmt.Errorf("%w: %v", ErrCertificate, err)
+	}
+	if leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return fmt.Errorf("%w: the key usage of %q doesn't allow digital signatures", ErrCertificate, leaf.Subject.CommonName)
+	}
+	return nil
+}
+
+// checkThumbprints compares the x5t and x5t#S256 with the leaf certificate, when they're given
+func checkThumbprints(leaf *x509.Certificate, sha1, sha256 string) error {
+	if sha1 != "" && sha1 != x5t(leaf) {
+		return fmt.Errorf("%w: x5t isn't the thumbprint of the leaf certificate", ErrCertificate)
+	}
+	if sha256 != "" && sha256 != x5tS256(leaf) {
+		return fmt.Errorf("%w: x5t#S256 isn't the thumbprint of the leaf certificate", ErrCertificate)
+	}
+	return nil
+}
+
+// certifiedKey is the key of the leaf certificate in the header's x5c, or at its x5u, once the
+// chain validates against the policy's Roots. It's nil without Roots, as a certificate anyone can
+// put in a header is only worth anything if it chains to a CA that's trusted, or when the header
+// has neither. The x5u is ignored without X5UPrefixes, it's fetched before the signature is
+// verified so it's only fetched from where the policy says. When the policy has an Issuer, from
+// discovery or a trust config, the leaf has to be for it, or any certificate the CAs issued could
+// sign for any issuer. The kid is the serial number, as for any other certificate
+func certifiedKey(ctx context.Context, header jws.Headers, policy Policy) (jwk.Key, error) {
+	if policy.Roots == nil {
+		return nil, nil
+	}
+	certs, err := decodeChain(header.X509CertChain())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if len(certs) == 0 && header.X509URL() != "" && len(policy.X5UPrefixes) > 0 {
+		if certs, err = fetchChain(ctx, header.X509URL(), policy); err != nil {
+			return nil, fmt.Errorf("%w: x5u: %v", ErrCertificate, err)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, nil
+	}
+	if err := validateChain(certs, policy.Roots); err != nil {
+		return nil, err
+	}
+	if err := checkThumbprints(certs[0], header.X509CertThumbprint(), header.X509CertThumbprintS256()); err != nil {
+		return nil, err
+	}
+	if policy.Issuer != "" {
+		if err := checkIssuer(certs[0], policy.Issuer); err != nil {
+			return nil, err
+		}
+	}
+	key, err := jwk.FromRaw(certs[0].PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCertificate, err)
+	}
+	key.Set(jwk.KeyIDKey, certs[0].SerialNumber.String())
+	return key, nil
+}
+
+// checkIssuer reports a leaf certificate that isn't for the issuer. One of its URI SANs has to be
+// the issuer, or one of its DNS SANs the issuer's host, or its common name when it has no DNS SANs
+func checkIssuer(leaf *x509.Certificate, issuer string) error {
+	for _, u := range leaf.URIs {
+		if u.String() == issuer {
+			return nil
+		}
+	}
+	host := issuer
+	if u, err := url.Parse(issuer); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	if leaf.VerifyHostname(host) == nil || (len(leaf.DNSNames) == 0 && leaf.Subject.CommonName == host) {
+		return nil
+	}
+	return fmt.Errorf("%w: the certificate %q isn't for the issuer %s", ErrCertificate, leaf.Subject.CommonName, issuer)
+}
+
+// checkKeyChain validates the x5c of a key against the policy's Roots, and checks that its leaf
+// certificate is for the key and matches its x5t and x5t#S256. A key without an x5c passes
+func checkKeyChain(key jwk.Key, policy Policy) error {
+	if policy.Roots == nil {
+		return nil
+	}
+	certs, err := decodeChain(key.X509CertChain())
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCertificate, describe(key), err)
+	}
+	if len(certs) == 0 {
+		return nil
+	}
+	if err := validateChain(certs, policy.Roots); err != nil {
+		return fmt.Errorf("%s: %w", describe(key), err)
+	}
+	raw, err := jwk.PublicRawKeyOf(key)
+	if err != nil {
+		return err
+	}
+	if leaf, ok := certs[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !leaf.Equal(raw) {
+		return fmt.Errorf("%w: the leaf certificate in the x5c of %s is for a different key", ErrCertificate, describe(key))
+	}
+	if err := checkThumbprints(certs[0], key.X509CertThumbprint(), key.X509CertThumbprintS256()); err != nil {
+		return fmt.Errorf("%s: %w", describe(key), err)
+	}
+	return nil
+}
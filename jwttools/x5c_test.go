@@ -0,0 +1,131 @@
+package jwttools
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAllowedX5U(t *testing.T) {
+	prefixes := []string{"https://pki.example.com/certs/", "https://idp.example.com"}
+	tests := []struct {
+		location string
+		ok       bool
+	}{
+		{"https://pki.example.com/certs/leaf.pem", true},
+		{"https://idp.example.com/chain.pem", true},
+		{"https://idp.example.com", true},
+		{"https://idp.example.com?chain", true},
+		{"https://idp.example.com.evil.net/chain.pem", false},
+		{"https://idp.example.com:8443/chain.pem", false},
+		{"https://pki.example.com/other/leaf.pem", false},
+		{"http://pki.example.com/certs/leaf.pem", false},
+		{"https://user@pki.example.com/certs/leaf.pem", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, test := range tests {
+		if err := allowedX5U(test.location, prefixes); (err == nil) != test.ok {
+			t.Errorf("allowedX5U(%q) = %v, want allowed %v", test.location, err, test.ok)
+		}
+	}
+	if err := allowedX5U("https://pki.example.com/certs/leaf.pem", nil); err == nil {
+		t.Errorf("an x5u was allowed without any prefixes")
+	}
+}
+
+// testCA is a CA and a function issuing leaf certificates from it
+func testCA(t *testing.T) (*x509.CertPool, func(template *x509.Certificate) Signer) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	serial := int64(1)
+	return roots, func(template *x509.Certificate) Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial++
+		template.SerialNumber = big.NewInt(serial)
+		template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Signer{Algorithm: "ES256", Key: key, Certificates: []*x509.Certificate{leaf}}
+	}
+}
+
+// A token with an x5c chaining to the bundle verifies without any other keys, but when the policy
+// names the issuer the leaf has to be for it, so another issuer's certificate can't sign for it
+func TestCertifiedKeyIssuer(t *testing.T) {
+	ctx := context.Background()
+	roots, issue := testCA(t)
+	idp, _ := url.Parse("https://idp.example.com/realm")
+	signers := map[string]Signer{
+		"dns":      issue(&x509.Certificate{Subject: pkix.Name{CommonName: "idp"}, DNSNames: []string{"idp.example.com"}}),
+		"uri":      issue(&x509.Certificate{URIs: []*url.URL{idp}}),
+		"cn":       issue(&x509.Certificate{Subject: pkix.Name{CommonName: "idp.example.com"}}),
+		"other":    issue(&x509.Certificate{Subject: pkix.Name{CommonName: "evil"}, DNSNames: []string{"evil.example.com"}}),
+		"cn-other": issue(&x509.Certificate{Subject: pkix.Name{CommonName: "idp.example.com"}, DNSNames: []string{"evil.example.com"}}),
+	}
+	tests := []struct {
+		signer string
+		issuer string
+		ok     bool
+	}{
+		{"dns", "", true},
+		{"other", "", true},
+		{"dns", "https://idp.example.com/realm", true},
+		{"uri", "https://idp.example.com/realm", true},
+		{"cn", "https://idp.example.com/realm", true},
+		{"uri", "https://idp.example.com/other", false},
+		{"other", "https://idp.example.com/realm", false},
+		{"cn-other", "https://idp.example.com/realm", false},
+	}
+	for _, test := range tests {
+		token, err := Mint(ctx, Claims{"iss": "https://idp.example.com/realm"}, signers[test.signer], MintOptions{X5C: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Verify(ctx, token, KeySources{}, Policy{Roots: roots, Issuer: test.issuer})
+		if test.ok && err != nil {
+			t.Errorf("%s for %q: %v", test.signer, test.issuer, err)
+		}
+		if !test.ok && !errors.Is(err, ErrCertificate) {
+			t.Errorf("%s for %q: got %v, want ErrCertificate", test.signer, test.issuer, err)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Package keys loads private keys, public keys and certificates for the jwt-tools commands.
+//
+// Keys can be in PEM, DER, JWK/JWKS, PKCS#12 or OpenSSH public key format and the format is
+// worked out from the data, so every command accepts every format. Certificates found alongside
+// keys are matched to them by public key. Nothing in here writes to stdout or exits, every
+// problem is returned as one of the errors below.
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"golang.org/x/crypto/ssh"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var (
+	// ErrNoKeys is returned when the data holds no keys or certificates at all
+	ErrNoKeys = errors.New("no keys or certificates found")
+	// ErrNoPrivateKey is returned by LoadPrivateKey when there are only public keys or certificates
+	ErrNoPrivateKey = errors.New("no private key found")
+	// ErrNoCertificate is returned by LoadCertificates when there are only keys
+	ErrNoCertificate = errors.New("no certificate found")
+	// ErrNoSecret is returned by LoadSecret when there's no symmetric (oct) JWK
+	ErrNoSecret = errors.New("no symmetric (oct) JWK found")
+	// ErrUnsupportedKey is returned for key types other than RSA, ECDSA and Ed25519
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	// ErrEncrypted is returned for password protected PEM keys, which aren't supported
+	ErrEncrypted = errors.New("encrypted PEM keys are not supported")
+	// ErrUnknownFormat is returned when the data isn't PEM, DER, JWK, PKCS#12 or an OpenSSH public key
+	ErrUnknownFormat = errors.New("not PEM, DER, JWK, PKCS#12 or OpenSSH format")
+)
+
+// ParseError says which file, and which part of it, couldn't be parsed
+type ParseError struct {
+	File   string // empty when parsing data rather than loading a file
+	Format string // PEM block type, DER, JWK, PKCS#12 or SSH
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%s: %v", e.Format, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Format, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Key is a key found in a file along with anything else that's known about it
+type Key struct {
+	Public       crypto.PublicKey
+	Private      crypto.Signer       // nil when only the public key is known
+	KeyID        string              // the JWK kid, or the certificate serial number
+	Algorithm    string              // the JWK alg, empty if it didn't come from a JWK
+	Use          string              // the JWK use, empty if it didn't come from a JWK
+	Certificates []*x509.Certificate // the certificate for this key first, then the rest of its chain
+}
+
+// DefaultAlgorithm is the JWS algorithm the tools sign with for a public key, or "" if the key type
+// isn't supported
+func DefaultAlgorithm(key crypto.PublicKey) string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return "RS256"
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return "ES256"
+		case 384:
+			return "ES384"
+		case 521:
+			return "ES512"
+		}
+	case ed25519.PublicKey:
+		return "EdDSA"
+	}
+	return ""
+}
+
+// SigningAlgorithm is the JWK alg of the key if it has one, otherwise the DefaultAlgorithm
+func (k *Key) SigningAlgorithm() string {
+	if k.Algorithm != "" {
+		return k.Algorithm
+	}
+	return DefaultAlgorithm(k.Public)
+}
+
+// Load reads every key and certificate in a file. The password is only used for PKCS#12
+func Load(filename, password string) ([]*Key, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	found, err := Parse(data, password)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			parseErr.File = filename
+			return nil, parseErr
+		}
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return found, nil
+}
+
+// LoadPrivateKey returns the first private key in a file
+func LoadPrivateKey(filename, password string) (*Key, error) {
+	found, err := Load(filename, password)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range found {
+		if key.Private != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", filename, ErrNoPrivateKey)
+}
+
+// LoadPublicKey returns the first key in a file, which may be from a private key or a certificate
+func LoadPublicKey(filename, password string) (*Key, error) {
+	found, err := Load(filename, password)
+	if err != nil {
+		return nil, err
+	}
+	return found[0], nil
+}
+
+// LoadSecret returns the first symmetric key in a JWK or JWKS file, along with its kid. Symmetric
+// keys aren't returned by Load as they have no public half
+func LoadSecret(filename string) ([]byte, string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, "", &ParseError{File: filename, Format: "JWK", Err: err}
+	}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if symmetric, ok := key.(jwk.SymmetricKey); ok {
+			return symmetric.Octets(), key.KeyID(), nil
+		}
+	}
+	return nil, "", fmt.Errorf("%s: %w", filename, ErrNoSecret)
+}
+
+// LoadCertificates returns the first certificate chain in a file, leaf first
+func LoadCertificates(filename, password string) ([]*x509.Certificate, error) {
+	found, err := Load(filename, password)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range found {
+		if len(key.Certificates) > 0 {
+			return key.Certificates, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", filename, ErrNoCertificate)
+}
+
+// Parse returns every key in the data, working out the format from the data itself.
+// All the certificates in the data are taken to be one chain, leaf first. Each key gets the part
+// of the chain that starts with its certificate, and if no key matches the leaf certificate then
+// a public key is made from it
+func Parse(data []byte, password string) ([]*Key, error) {
+	var found []*Key
+	var certs []*x509.Certificate
+	var err error
+
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		found, err = parseJWK(trimmed)
+	case bytes.Contains(trimmed, []byte("-----BEGIN ")):
+		found, certs, err = parsePEM(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("ssh-")) || bytes.HasPrefix(trimmed, []byte("ecdsa-sha2-")):
+		found, err = parseSSH(trimmed)
+	default:
+		found, certs, err = parseDER(data, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) > 0 {
+		leafMatched := false
+		for _, key := range found {
+			for i, cert := range certs {
+				if samePublicKey(key.Public, cert.PublicKey) {
+					key.Certificates = certs[i:]
+					if key.KeyID == "" {
+						key.KeyID = cert.SerialNumber.String()
+					}
+					leafMatched = leafMatched || i == 0
+					break
+				}
+			}
+		}
+		if !leafMatched {
+			found = append(found, &Key{
+				Public:       certs[0].PublicKey,
+				KeyID:        certs[0].SerialNumber.String(),
+				Certificates: certs,
+			})
+		}
+	}
+	if len(found) == 0 {
+		return nil, ErrNoKeys
+	}
+	return found, nil
+}
+
+// samePublicKey compares two public keys of any type
+func samePublicKey(a, b crypto.PublicKey) bool {
+	pub, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(b)
+}
+
+// newPrivateKey checks the type of a parsed private key
+func newPrivateKey(raw interface{}) (*Key, error) {
+	switch raw.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		signer := raw.(crypto.Signer)
+		return &Key{Public: signer.Public(), Private: signer}, nil
+	}
+	return nil, fmt.Errorf("%w %T", ErrUnsupportedKey, raw)
+}
+
+// newPublicKey checks the type of a parsed public key
+func newPublicKey(raw interface{}) (*Key, error) {
+	switch raw.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return &Key{Public: raw}, nil
+	}
+	return nil, fmt.Errorf("%w %T", ErrUnsupportedKey, raw)
+}
+
+// parsePrivateKeyDER tries each of the private key encodings in turn
+func parsePrivateKeyDER(der []byte) (*Key, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return newPrivateKey(key)
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return newPrivateKey(key)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return newPrivateKey(key)
+}
+
+// parsePublicKeyDER tries each of the public key encodings in turn
+func parsePublicKeyDER(der []byte) (*Key, error) {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return newPublicKey(key)
+	}
+	key, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return newPublicKey(key)
+}
+
+// parsePEM reads all the PEM blocks
+func parsePEM(data []byte) ([]*Key, []*x509.Certificate, error) {
+	var found []*Key
+	var certs []*x509.Certificate
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		// IsEncryptedPEMBlock is deprecated but it's only used to give a better error
+		if block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block) {
+			return nil, nil, &ParseError{Format: block.Type, Err: ErrEncrypted}
+		}
+		var key *Key
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				certs = append(certs, cert)
+			}
+		case "PUBLIC KEY", "RSA PUBLIC KEY":
+			key, err = parsePublicKeyDER(block.Bytes)
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			key, err = parsePrivateKeyDER(block.Bytes)
+		case "EC PARAMETERS":
+			// openssl ecparam -genkey puts these before the key
+		default:
+			err = ErrUnknownFormat
+		}
+		if err != nil {
+			return nil, nil, &ParseError{Format: block.Type, Err: err}
+		}
+		if key != nil {
+			found = append(found, key)
+		}
+	}
+	return found, certs, nil
+}
+
+// parseDER tries the data as a certificate, then a key, then PKCS#12
+func parseDER(data []byte, password string) ([]*Key, []*x509.Certificate, error) {
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return nil, certs, nil
+	}
+	if key, err := parsePrivateKeyDER(data); err == nil {
+		return []*Key{key}, nil, nil
+	} else if errors.Is(err, ErrUnsupportedKey) {
+		return nil, nil, &ParseError{Format: "DER", Err: err}
+	}
+	if key, err := parsePublicKeyDER(data); err == nil {
+		return []*Key{key}, nil, nil
+	} else if errors.Is(err, ErrUnsupportedKey) {
+		return nil, nil, &ParseError{Format: "DER", Err: err}
+	}
+	// PKCS#12 can hold a chain, which pkcs12.Decode can't cope with, so convert it to PEM
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, nil, &ParseError{Format: "PKCS#12", Err: err}
+		}
+		return nil, nil, ErrUnknownFormat
+	}
+	var pemData []byte
+	for _, block := range blocks {
+		// ToPEM adds the bag attributes as headers, they aren't needed
+		pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: block.Bytes})...)
+	}
+	found, certs, err := parsePEM(pemData)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			parseErr.Format = "PKCS#12 " + parseErr.Format
+		}
+		return nil, nil, err
+	}
+	return found, certs, nil
+}
+
+// parseJWK reads a JWK or a JWKS
+func parseJWK(data []byte) ([]*Key, error) {
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, &ParseError{Format: "JWK", Err: err}
+	}
+	var found []*Key
+	for i := 0; i < set.Len(); i++ {
+		jwkKey, _ := set.Key(i)
+		var raw interface{}
+		if err := jwkKey.Raw(&raw); err != nil {
+			return nil, &ParseError{Format: "JWK", Err: err}
+		}
+		var key *Key
+		switch jwkKey.KeyType() {
+		case "RSA", "EC", "OKP":
+		default:
+			return nil, &ParseError{Format: "JWK", Err: fmt.Errorf("%w %s", ErrUnsupportedKey, jwkKey.KeyType())}
+		}
+		if _, isSigner := raw.(crypto.Signer); isSigner {
+			key, err = newPrivateKey(raw)
+		} else {
+			key, err = newPublicKey(raw)
+		}
+		if err != nil {
+			return nil, &ParseError{Format: "JWK", Err: err}
+		}
+		key.KeyID = jwkKey.KeyID()
+		if jwkKey.Algorithm() != nil {
+			key.Algorithm = jwkKey.Algorithm().String()
+		}
+		key.Use = jwkKey.KeyUsage()
+		if chain := jwkKey.X509CertChain(); chain != nil {
+			for j := 0; j < chain.Len(); j++ {
+				encoded, _ := chain.Get(j)
+				der, err := base64.StdEncoding.DecodeString(string(encoded))
+				if err != nil {
+					return nil, &ParseError{Format: "JWK x5c", Err: err}
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, &ParseError{Format: "JWK x5c", Err: err}
+				}
+				key.Certificates = append(key.Certificates, cert)
+			}
+		}
+		found = append(found, key)
+	}
+	return found, nil
+}
+
+// parseSSH reads an OpenSSH authorized_keys format public key
+func parseSSH(data []byte) ([]*Key, error) {
+	sshKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, &ParseError{Format: "SSH", Err: err}
+	}
+	cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, &ParseError{Format: "SSH", Err: fmt.Errorf("%w %s", ErrUnsupportedKey, sshKey.Type())}
+	}
+	key, err := newPublicKey(cryptoKey.CryptoPublicKey())
+	if err != nil {
+		return nil, &ParseError{Format: "SSH", Err: err}
+	}
+	return []*Key{key}, nil
+}
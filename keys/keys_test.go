@@ -0,0 +1,311 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"golang.org/x/crypto/ssh"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// the keys are generated once, RSA is slow
+var (
+	generate sync.Once
+	rsaKey   *rsa.PrivateKey
+	ecKey    *ecdsa.PrivateKey
+	edKey    ed25519.PrivateKey
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+	rsaCert  *x509.Certificate // for rsaKey, issued by caCert
+	ecCert   *x509.Certificate // for ecKey, self signed
+)
+
+func testKeys(t *testing.T) {
+	t.Helper()
+	generate.Do(func() {
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+		if ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+		if _, edKey, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+		if caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+		caCert = certify(t, 1, caKey.Public(), caKey, nil, true)
+		rsaCert = certify(t, 2, rsaKey.Public(), caKey, caCert, false)
+		ecCert = certify(t, 3, ecKey.Public(), ecKey, nil, false)
+	})
+	if rsaKey == nil {
+		t.Fatal("the test keys weren't generated")
+	}
+}
+
+// certify makes a certificate for the public key, self signed when there's no parent
+func certify(t *testing.T, serial int64, public crypto.PublicKey, signer crypto.Signer, parent *x509.Certificate, ca bool) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, public, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func pemBlock(kind string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+}
+
+func pkcs8(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func pkixDER(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func jwkJSON(t *testing.T, raw interface{}, kid string) []byte {
+	t.Helper()
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != "" {
+		key.Set(jwk.KeyIDKey, kid)
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParse(t *testing.T) {
+	testKeys(t)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey, err := ssh.NewPublicKey(edKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := pkcs12.Modern.Encode(rsaKey, rsaCert, []*x509.Certificate{caCert}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWK := jwkJSON(t, rsaKey, "r1")
+	ecJWK := jwkJSON(t, ecKey.Public(), "e1")
+
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		public   []crypto.PublicKey // the keys found, in order
+		private  []bool             // whether each has its private key
+		kids     []string           // the kid of each
+	}{
+		{"PKCS#1 PEM", pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), "", []crypto.PublicKey{rsaKey.Public()}, []bool{true}, []string{""}},
+		{"SEC 1 PEM", pemBlock("EC PRIVATE KEY", ecDER), "", []crypto.PublicKey{ecKey.Public()}, []bool{true}, []string{""}},
+		{"PKCS#8 PEM", pemBlock("PRIVATE KEY", pkcs8(t, edKey)), "", []crypto.PublicKey{edKey.Public()}, []bool{true}, []string{""}},
+		{"public PEM", pemBlock("PUBLIC KEY", pkixDER(t, ecKey.Public())), "", []crypto.PublicKey{ecKey.Public()}, []bool{false}, []string{""}},
+		{"PKCS#1 public PEM", pemBlock("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)), "", []crypto.PublicKey{rsaKey.Public()}, []bool{false}, []string{""}},
+		{"certificate PEM", pemBlock("CERTIFICATE", ecCert.Raw), "", []crypto.PublicKey{ecKey.Public()}, []bool{false}, []string{"3"}},
+		{"PKCS#8 DER", pkcs8(t, rsaKey), "", []crypto.PublicKey{rsaKey.Public()}, []bool{true}, []string{""}},
+		{"public DER", pkixDER(t, edKey.Public()), "", []crypto.PublicKey{edKey.Public()}, []bool{false}, []string{""}},
+		{"certificate DER", ecCert.Raw, "", []crypto.PublicKey{ecKey.Public()}, []bool{false}, []string{"3"}},
+		{"PKCS#12", p12, "secret", []crypto.PublicKey{rsaKey.Public()}, []bool{true}, []string{"2"}},
+		{"JWK", rsaJWK, "", []crypto.PublicKey{rsaKey.Public()}, []bool{true}, []string{"r1"}},
+		{"JWKS", []byte(`{"keys":[` + string(rsaJWK) + `,` + string(ecJWK) + `]}`), "", []crypto.PublicKey{rsaKey.Public(), ecKey.Public()}, []bool{true, false}, []string{"r1", "e1"}},
+		{"OpenSSH", ssh.MarshalAuthorizedKey(sshKey), "", []crypto.PublicKey{edKey.Public()}, []bool{false}, []string{""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, err := Parse(test.data, test.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != len(test.public) {
+				t.Fatalf("found %d keys, want %d", len(found), len(test.public))
+			}
+			for i, key := range found {
+				if !samePublicKey(key.Public, test.public[i]) {
+					t.Errorf("key %d is the wrong key, a %T", i, key.Public)
+				}
+				if (key.Private != nil) != test.private[i] {
+					t.Errorf("key %d private key %v, want %v", i, key.Private != nil, test.private[i])
+				}
+				if key.KeyID != test.kids[i] {
+					t.Errorf("key %d kid %q, want %q", i, key.KeyID, test.kids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testKeys(t)
+	p12, err := pkcs12.Modern.Encode(ecKey, ecCert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		want     error
+	}{
+		{"encrypted PKCS#8", pemBlock("ENCRYPTED PRIVATE KEY", []byte{1, 2, 3}), "", ErrEncrypted},
+		{"oct JWK", []byte(`{"kty":"oct","k":"c2VjcmV0"}`), "", ErrUnsupportedKey},
+		{"unknown PEM block", pemBlock("DSA PRIVATE KEY", []byte{1, 2, 3}), "", ErrUnknownFormat},
+		{"garbage", []byte{0, 1, 2, 3}, "", ErrUnknownFormat},
+		{"no keys", []byte("-----BEGIN nothing"), "", ErrNoKeys},
+		{"wrong PKCS#12 password", p12, "wrong", pkcs12.ErrIncorrectPassword},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.data, test.password)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCertificateMatching(t *testing.T) {
+	testKeys(t)
+	rsaPEM := pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	chain := append(pemBlock("CERTIFICATE", rsaCert.Raw), pemBlock("CERTIFICATE", caCert.Raw)...)
+
+	// the key gets its certificate, the rest of the chain and the serial number as its kid
+	found, err := Parse(append(append([]byte{}, rsaPEM...), chain...), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("found %d keys, want 1", len(found))
+	}
+	if key := found[0]; len(key.Certificates) != 2 || !key.Certificates[0].Equal(rsaCert) || key.KeyID != "2" {
+		t.Errorf("got %d certificates and kid %q, want the chain and kid 2", len(key.Certificates), key.KeyID)
+	}
+
+	// a leaf certificate for another key is a key of its own
+	found, err = Parse(append(append([]byte{}, rsaPEM...), pemBlock("CERTIFICATE", ecCert.Raw)...), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("found %d keys, want 2", len(found))
+	}
+	if len(found[0].Certificates) != 0 || found[0].KeyID != "" {
+		t.Errorf("the RSA key got a certificate that isn't its own")
+	}
+	if !samePublicKey(found[1].Public, ecKey.Public()) || found[1].Private != nil || found[1].KeyID != "3" {
+		t.Errorf("the certificate's key wasn't added")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	testKeys(t)
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	certFile := write("cert.pem", pemBlock("CERTIFICATE", ecCert.Raw))
+	keyFile := write("key.pem", pemBlock("PRIVATE KEY", pkcs8(t, ecKey)))
+	octFile := write("oct.json", []byte(`{"kty":"oct","k":"c2VjcmV0","kid":"s1"}`))
+	encrypted := write("encrypted.pem", pemBlock("ENCRYPTED PRIVATE KEY", []byte{1, 2, 3}))
+
+	if _, err := LoadPrivateKey(certFile, ""); !errors.Is(err, ErrNoPrivateKey) {
+		t.Errorf("LoadPrivateKey of a certificate: got %v, want ErrNoPrivateKey", err)
+	}
+	if key, err := LoadPrivateKey(keyFile, ""); err != nil || key.Private == nil {
+		t.Errorf("LoadPrivateKey: %v", err)
+	}
+	if _, err := LoadCertificates(keyFile, ""); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("LoadCertificates of a key: got %v, want ErrNoCertificate", err)
+	}
+	if _, _, err := LoadSecret(keyFile); err == nil {
+		t.Errorf("LoadSecret of a PEM key didn't fail")
+	}
+	if secret, kid, err := LoadSecret(octFile); err != nil || string(secret) != "secret" || kid != "s1" {
+		t.Errorf("LoadSecret: got %q, %q, %v", secret, kid, err)
+	}
+	_, err := Load(encrypted, "")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != encrypted || !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Load of an encrypted key: got %v, want a ParseError naming the file", err)
+	}
+}
+
+func TestDefaultAlgorithm(t *testing.T) {
+	testKeys(t)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  crypto.PublicKey
+		want string
+	}{
+		{rsaKey.Public(), "RS256"},
+		{ecKey.Public(), "ES256"},
+		{p384.Public(), "ES384"},
+		{edKey.Public(), "EdDSA"},
+		{"not a key", ""},
+	}
+	for _, test := range tests {
+		if got := DefaultAlgorithm(test.key); got != test.want {
+			t.Errorf("DefaultAlgorithm(%T) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
@@ -1,14 +0,0 @@
-module mk-jwks
-
-go 1.23.0
-
-toolchain go1.23.9
-
-require github.com/go-jose/go-jose v2.6.3+incompatible
-
-require (
-	github.com/google/go-cmp v0.7.0 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
-	golang.org/x/crypto v0.38.0 // indirect
-	gopkg.in/go-jose/go-jose.v2 v2.6.3 // indirect
-)
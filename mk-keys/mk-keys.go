@@ -0,0 +1,14 @@
+package main
+
+/* mk-keys is the same as "jwt-tools keys", kept so that existing scripts still work */
+
+import (
+	"os"
+
+	"github.com/ps258/jwt-tools/internal/cli"
+	"github.com/ps258/jwt-tools/internal/cmd/keygen"
+)
+
+func main() {
+	os.Exit(keygen.Main(cli.NewGlobals(), os.Args[1:]))
+}